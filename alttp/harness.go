@@ -0,0 +1,515 @@
+package alttp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/snes/asm"
+	"github.com/alttpo/snes/mapping/lorom"
+	"io"
+)
+
+// fixed addresses in the HWIO.Dyn area the harness routines are emitted at; the rest follow init at $00:5000:
+const (
+	b02LoadUnderworldSupertilePC uint32 = 0x02_5200
+	b00HandleRoomTagsPC          uint32 = 0x00_5300
+	b00UploadPPUPC               uint32 = 0x00_5400
+	b00StepFramePC               uint32 = 0x00_5600
+)
+
+// Config configures NewSystem:
+type Config struct {
+	Logger io.Writer // receives the profile relocations and the emitted asm listing
+
+	// Symbols are the labels of the ROM from a disassembly or ROM hack build. They relocate the profile's
+	// labelled routines and name addresses in traces and errors:
+	Symbols *emulator.Symbols
+
+	// PatchSongBankLoading patches out Underworld_LoadSongBankIfNeeded instead of letting the game upload its
+	// song banks through the emulated APU handshake:
+	PatchSongBankLoading bool
+}
+
+// Harness is a system with the game initialized and the harness routines emitted into its HWIO.Dyn area, with
+// the addresses needed to drive it. Other systems are cloned from System with InitEmulatorFrom:
+type Harness struct {
+	System  *emulator.System
+	Profile *ROMProfile // address profile of the ROM
+
+	// Symbols names the harness routines and labels, the addresses in Profile and the Config's symbols for
+	// traces and errors; it is also the System's Symbols:
+	Symbols *emulator.Symbols
+
+	// entry points of the harness routines:
+	LoadAndDrawRoomPC             uint32 // loads and draws the supertile set at LoadAndDrawRoomSetSupertilePC
+	LoadAndDrawRoomSetSupertilePC uint32 // 16-bit supertile operand of LoadAndDrawRoomPC
+	HandleRoomTagsPC              uint32 // runs the room's tags, capturing each frame with WDM $FF
+	LoadEntrancePC                uint32 // loads the entrance set at SetEntranceIDPC
+	SetEntranceIDPC               uint32 // 8-bit entrance ID operand of LoadEntrancePC
+	LoadSupertilePC               uint32 // loads the supertile in WRAM $A0 using the loaded entrance's graphics
+	DonePC                        uint32 // every harness routine ends here with STP
+	StepFramePC                   uint32 // runs one frame of the game's main loop for the joypad input latched by System.RunFrames
+}
+
+// NewSystem creates the CPU-only emulator for a headerless ROM image of the given version, resets the game and
+// runs its initialization so the returned harness is ready to load entrances:
+func NewSystem(rom []byte, version ROMVersion, cfg Config) (h *Harness, err error) {
+	h = &Harness{
+		HandleRoomTagsPC: b00HandleRoomTagsPC,
+		StepFramePC:      b00StepFramePC,
+	}
+
+	if _, ok := romProfiles[version]; !ok && cfg.Symbols != nil {
+		// no built-in profile; the symbols must supply every address:
+		if h.Profile, err = ProfileFromSymbols(version, cfg.Symbols, rom); err != nil {
+			return
+		}
+	} else if h.Profile, err = ProfileFor(version); err != nil {
+		return
+	} else if cfg.Symbols != nil {
+		var relocated []string
+		if h.Profile, relocated, err = h.Profile.WithSymbols(cfg.Symbols, rom); err != nil {
+			return
+		}
+		for _, r := range relocated {
+			if cfg.Logger != nil {
+				fmt.Fprintf(cfg.Logger, "profile: %s relocated by symbols\n", r)
+			}
+		}
+	}
+	if err = h.Profile.Verify(rom, cfg.PatchSongBankLoading); err != nil {
+		return
+	}
+
+	e := &emulator.System{
+		Logger:    cfg.Logger,
+		LoggerCPU: nil,
+		ROM:       make([]byte, 0x100_0000),
+	}
+	h.System = e
+
+	if err = e.InitEmulator(); err != nil {
+		return
+	}
+
+	copy(e.ROM, rom)
+
+	err = h.setup(cfg)
+	return
+}
+
+// StepUnderworld hands control of Link to the joypad and runs Module07_Underworld a frame at a time for each
+// frame of e.Joypad.Script. onFrame is called after each frame and returns false to stop early:
+func (h *Harness) StepUnderworld(e *emulator.System, onFrame func(frame int) bool) (err error) {
+	// Module07_Underworld:
+	e.WRAM[0x10] = 0x07
+	e.WRAM[0x11] = 0x00
+	// no cutscene:
+	e.WRAM[0x02E4] = 0x00
+
+	if err = e.RunFrames(h.StepFramePC, onFrame); err != nil {
+		err = fmt.Errorf("alttp: step underworld: %w", err)
+	}
+	return
+}
+
+// LoadEntrance runs the game's entrance loading module for the given entrance ID on e, a system cloned from
+// the harness's:
+func (h *Harness) LoadEntrance(e *emulator.System, eID uint8) (err error) {
+	// poke the entrance ID into our asm code:
+	e.HWIO.Dyn[h.SetEntranceIDPC-0x5000] = eID
+
+	// load the entrance and draw the room:
+	err = e.ExecAt(h.LoadEntrancePC, h.DonePC)
+	return
+}
+
+// EntranceSupertile reads the supertile entrance eID starts in from the ROM's entrance table, which the game's
+// entrance loading writes to $A0:
+func (h *Harness) EntranceSupertile(eID uint8) uint16 {
+	lin, _ := lorom.BusAddressToPak(h.Profile.EntranceRooms + uint32(eID)<<1)
+	return binary.LittleEndian.Uint16(h.System.ROM[lin:])
+}
+
+// routine names a ROM address for asm listing comments:
+func (h *Harness) routine(addr uint32) string {
+	return fmt.Sprintf("%s#_%06X", h.Symbols.Format(addr), addr)
+}
+
+// label defines an emitter label at the current address and names it in Symbols:
+func (h *Harness) label(a *asm.Emitter, name string) uint32 {
+	addr := a.Label(name)
+	h.Symbols.Add(addr, name)
+	return addr
+}
+
+func (h *Harness) setup(cfg Config) (err error) {
+	var a *asm.Emitter
+
+	e := h.System
+	p := h.Profile
+	h.Symbols = emulator.NewSymbols()
+	p.addSymbols(h.Symbols)
+	h.Symbols.Merge(cfg.Symbols)
+	e.Symbols = h.Symbols
+
+	// initialize game:
+	e.CPU.Reset()
+	//#_008029: JSR Sound_LoadIntroSongBank		// skip this
+	// this is useless zeroing of memory; don't need to run it
+	//#_00802C: JSR Startup_InitializeMemory
+	if err = e.Exec(p.ResetStop); err != nil {
+		err = fmt.Errorf("alttp: reset: %w", err)
+		return
+	}
+
+	{
+		// the game uploads its OAM and palette buffers and its PPU register shadows from the NMI handler which
+		// we never run, so do it here:
+		a = asm.NewEmitter(e.HWIO.Dyn[b00UploadPPUPC&0xFFFF-0x5000:], true)
+		a.SetBase(b00UploadPPUPC)
+		h.label(a, "uploadPPU")
+		a.PHP()
+		a.SEP(0x30)
+
+		a.Comment("DMA $7E0800[$220] to OAMDATA")
+		a.LDA_imm8_b(0x00)
+		a.STA_long(0x00_2102)
+		a.STA_long(0x00_2103)
+		a.STA_long(0x00_4300)
+		a.STA_long(0x00_4302)
+		a.LDA_imm8_b(0x04)
+		a.STA_long(0x00_4301)
+		a.LDA_imm8_b(0x08)
+		a.STA_long(0x00_4303)
+		a.LDA_imm8_b(0x7E)
+		a.STA_long(0x00_4304)
+		a.LDA_imm8_b(0x20)
+		a.STA_long(0x00_4305)
+		a.LDA_imm8_b(0x02)
+		a.STA_long(0x00_4306)
+		a.LDA_imm8_b(0x01)
+		a.STA_long(0x00_420B)
+
+		a.Comment("only when the palette buffer changed")
+		a.LDA_dp(0x15)
+		a.BEQ("no_cgram_update")
+		a.STZ_dp(0x15)
+
+		a.Comment("DMA $7EC500[$200] to CGDATA")
+		a.LDA_imm8_b(0x00)
+		a.STA_long(0x00_2121)
+		a.STA_long(0x00_4300)
+		a.STA_long(0x00_4302)
+		a.STA_long(0x00_4305)
+		a.LDA_imm8_b(0x22)
+		a.STA_long(0x00_4301)
+		a.LDA_imm8_b(0xC5)
+		a.STA_long(0x00_4303)
+		a.LDA_imm8_b(0x7E)
+		a.STA_long(0x00_4304)
+		a.LDA_imm8_b(0x02)
+		a.STA_long(0x00_4306)
+		a.LDA_imm8_b(0x01)
+		a.STA_long(0x00_420B)
+
+		h.label(a, "no_cgram_update")
+		a.Comment("PPU registers from their shadows")
+		for _, r := range []struct {
+			shadow uint8
+			reg    uint32
+		}{
+			{0x94, 0x00_2105}, // BGMODE
+			{0x1C, 0x00_212C}, // TM
+			{0x1D, 0x00_212D}, // TS
+			{0x1E, 0x00_212E}, // TMW
+			{0x1F, 0x00_212F}, // TSW
+			{0x99, 0x00_2130}, // CGWSEL
+			{0x9A, 0x00_2131}, // CGADSUB
+			{0x9C, 0x00_2132}, // COLDATA
+			{0x9D, 0x00_2132},
+			{0x9E, 0x00_2132},
+			{0x96, 0x00_2123}, // W12SEL
+			{0x97, 0x00_2124}, // W34SEL
+			{0x98, 0x00_2125}, // WOBJSEL
+			{0xE2, 0x00_210D}, // BG1HOFS
+			{0xE3, 0x00_210D},
+			{0xE8, 0x00_210E}, // BG1VOFS
+			{0xE9, 0x00_210E},
+			{0xE0, 0x00_210F}, // BG2HOFS
+			{0xE1, 0x00_210F},
+			{0xE6, 0x00_2110}, // BG2VOFS
+			{0xE7, 0x00_2110},
+			{0x9B, 0x00_420C}, // HDMAEN
+		} {
+			a.LDA_dp(r.shadow)
+			a.STA_long(r.reg)
+		}
+
+		a.PLP()
+		a.RTS()
+
+		// finalize labels
+		if err = a.Finalize(); err != nil {
+			return
+		}
+		a.WriteTextTo(e.Logger)
+	}
+
+	{
+		// must execute in bank $01
+		a = asm.NewEmitter(e.HWIO.Dyn[0x01_5100&0xFFFF-0x5000:], true)
+		a.SetBase(0x01_5100)
+
+		{
+			h.LoadAndDrawRoomPC = h.label(a, "loadAndDrawRoom")
+			a.REP(0x30)
+			h.LoadAndDrawRoomSetSupertilePC = h.label(a, "loadAndDrawRoomSetSupertile") + 1
+			a.LDA_imm16_w(0x0000)
+			a.STA_dp(0xA0)
+			a.SEP(0x30)
+
+			// loads header and draws room
+			a.Comment(h.routine(p.UnderworldLoadRoom))
+			a.JSL(p.UnderworldLoadRoom)
+
+			a.Comment(h.routine(p.LoadCustomTileAttributes))
+			a.JSL(p.LoadCustomTileAttributes)
+			a.Comment(h.routine(p.UnderworldLoadAttributes))
+			a.JSL(p.UnderworldLoadAttributes)
+
+			// then JSR Underworld_LoadHeader#_01B564 to reload the doors into $19A0[16]
+			//a.BRA("jslUnderworld_LoadHeader")
+			a.STP()
+		}
+
+		// finalize labels
+		if err = a.Finalize(); err != nil {
+			return
+		}
+		a.WriteTextTo(e.Logger)
+	}
+
+	// this routine renders a supertile assuming gfx tileset and palettes already loaded:
+	{
+		// emit into our custom $02:5100 routine:
+		a = asm.NewEmitter(e.HWIO.Dyn[b02LoadUnderworldSupertilePC&0xFFFF-0x5000:], true)
+		a.SetBase(b02LoadUnderworldSupertilePC)
+		h.label(a, "loadUnderworldSupertile")
+		a.Comment("setup bank restore back to $00")
+		a.SEP(0x30)
+		a.LDA_imm8_b(0x00)
+		a.PHA()
+		a.PLB()
+		a.Comment("in Underworld_LoadEntrance_DoPotsBlocksTorches at PHB and bank switch to $7e")
+		a.JSR_abs(uint16(p.DoPotsBlocksTorches))
+		a.Comment("Module06_UnderworldLoad after JSR Underworld_LoadEntrance")
+		a.JMP_abs_imm16_w(uint16(p.Module06AfterLoadEntrance))
+		a.Comment("implied RTL")
+		a.WriteTextTo(e.Logger)
+	}
+
+	{
+		// emit into our custom $00:5600 routine; one frame of the main loop with the NMI's joypad read first:
+		a = asm.NewEmitter(e.HWIO.Dyn[b00StepFramePC&0xFFFF-0x5000:], true)
+		a.SetBase(b00StepFramePC)
+		h.label(a, "stepFrame")
+		a.SEP(0x30)
+
+		a.Comment("NMI_ReadJoypads")
+		a.JSR_abs(uint16(p.NMIReadJoypads))
+		a.Comment("frame counter")
+		a.INC_dp(0x1A)
+		a.Comment("JSL Module_MainRouting")
+		a.JSL(p.ModuleMainRouting)
+		a.Comment("NMI_PrepareSprites")
+		a.JSR_abs(uint16(p.NMIPrepareSprites))
+		a.Comment("NMI_DoUpdates")
+		a.JSR_abs(uint16(p.NMIDoUpdates))
+		a.Comment("upload OAM and palette buffers and PPU registers")
+		a.JSR_abs(uint16(b00UploadPPUPC))
+		a.STP()
+
+		// finalize labels
+		if err = a.Finalize(); err != nil {
+			return
+		}
+		a.WriteTextTo(e.Logger)
+	}
+
+	{
+		// emit into our custom $00:5000 routine:
+		a = asm.NewEmitter(e.HWIO.Dyn[:], true)
+		a.SetBase(0x00_5000)
+		h.label(a, "init")
+		a.SEP(0x30)
+
+		a.Comment(h.routine(p.InitializeTriforceIntro) + ": sets up initial state")
+		a.JSL(p.InitializeTriforceIntro)
+		a.Comment(h.routine(p.LoadDefaultTileAttributes))
+		a.JSL(p.LoadDefaultTileAttributes)
+
+		// general world state:
+		a.Comment("disable rain")
+		a.LDA_imm8_b(0x02)
+		a.STA_long(0x7EF3C5)
+
+		a.Comment("no bed cutscene")
+		a.LDA_imm8_b(0x10)
+		a.STA_long(0x7EF3C6)
+
+		h.LoadEntrancePC = h.label(a, "loadEntrance")
+		a.SEP(0x30)
+		// prepare to call the underworld room load module:
+		a.Comment("module $06, submodule $00:")
+		a.LDA_imm8_b(0x06)
+		a.STA_dp(0x10)
+		a.STZ_dp(0x11)
+		a.STZ_dp(0xB0)
+
+		a.Comment("dungeon entrance DungeonID")
+		h.SetEntranceIDPC = h.label(a, "setEntranceID") + 1
+		a.LDA_imm8_b(0x08)
+		a.STA_abs(0x010E)
+
+		// loads a dungeon given an entrance ID:
+		a.Comment("JSL Module_MainRouting")
+		a.JSL(p.ModuleMainRouting)
+		a.BRA("updateVRAM")
+
+		h.LoadSupertilePC = h.label(a, "loadSupertile")
+		a.SEP(0x30)
+		a.INC_abs(0x0710)
+		a.Comment("Intro_InitializeDefaultGFX after JSL DecompressAnimatedUnderworldTiles")
+		a.JSL(p.InitializeDefaultGFX)
+		a.STZ_dp(0x11)
+		a.Comment("LoadUnderworldSupertile")
+		a.JSL(b02LoadUnderworldSupertilePC)
+
+		h.label(a, "updateVRAM")
+		// this code sets up the DMA transfer parameters for animated BG tiles:
+		a.Comment("NMI_PrepareSprites")
+		a.JSR_abs(uint16(p.NMIPrepareSprites))
+		a.Comment("NMI_DoUpdates")
+		a.JSR_abs(uint16(p.NMIDoUpdates))
+		a.Comment("upload OAM and palette buffers and PPU registers")
+		a.JSR_abs(uint16(b00UploadPPUPC))
+
+		// Exec stops before this STP when it is the done PC:
+		h.DonePC = h.label(a, "done")
+		a.STP()
+
+		// finalize labels
+		if err = a.Finalize(); err != nil {
+			return
+		}
+		a.WriteTextTo(e.Logger)
+	}
+
+	{
+		// emit into our custom $00:5300 routine:
+		a = asm.NewEmitter(e.HWIO.Dyn[b00HandleRoomTagsPC&0xFFFF-0x5000:], true)
+		a.SetBase(b00HandleRoomTagsPC)
+		h.label(a, "handleRoomTags")
+
+		a.SEP(0x30)
+
+		a.Comment("Module07_Underworld")
+		a.LDA_imm8_b(0x07)
+		a.STA_dp(0x10)
+		a.STZ_dp(0x11)
+		a.STZ_dp(0xB0)
+
+		//write8(e.WRAM[:], 0x04BA, 0)
+		a.Comment("no cutscene")
+		a.STZ_abs(0x02E4)
+		a.Comment("enable tags")
+		a.STZ_abs(0x04C7)
+
+		//a.Comment("Graphics_LoadChrHalfSlot#_00E43A")
+		//a.JSL(0x00_E43A)
+		a.Comment(h.routine(p.UnderworldHandleRoomTags))
+		a.JSL(p.UnderworldHandleRoomTags)
+
+		// check if submodule changed:
+		a.LDA_dp(0x11)
+		a.BEQ("no_submodule")
+
+		h.label(a, "continue_submodule")
+		a.Comment("JSL Module_MainRouting")
+		a.JSL(p.ModuleMainRouting)
+
+		h.label(a, "no_submodule")
+		// this code sets up the DMA transfer parameters for animated BG tiles:
+		a.Comment("NMI_PrepareSprites")
+		a.JSR_abs(uint16(p.NMIPrepareSprites))
+
+		// fake NMI:
+		//a.REP(0x30)
+		//a.PHD()
+		//a.PHB()
+		//a.LDA_imm16_w(0)
+		//a.TCD()
+		//a.PHK()
+		//a.PLB()
+		//a.SEP(0x30)
+		a.Comment("NMI_DoUpdates")
+		a.JSR_abs(uint16(p.NMIDoUpdates))
+		a.Comment("upload OAM and palette buffers and PPU registers")
+		a.JSR_abs(uint16(b00UploadPPUPC))
+		//a.PLB()
+		//a.PLD()
+
+		a.Comment("capture frame")
+		a.WDM(0xFF)
+
+		a.LDA_dp(0x11)
+		a.BNE("continue_submodule")
+
+		a.STZ_dp(0x11)
+		a.STP()
+
+		// finalize labels
+		if err = a.Finalize(); err != nil {
+			return
+		}
+		a.WriteTextTo(e.Logger)
+	}
+
+	if cfg.PatchSongBankLoading {
+		// skip over music & sfx loading:
+		a = newEmitterAt(e, p.LoadSongBankIfNeededCall, true)
+		//#_028293: JSR Underworld_LoadSongBankIfNeeded
+		a.JMP_abs_imm16_w(uint16(p.LoadSongBankIfNeededExit))
+		//.exit
+		//#_0282BC: SEP #$20
+		//#_0282BE: RTL
+		a.WriteTextTo(e.Logger)
+	}
+
+	{
+		// patch out RebuildHUD:
+		a = newEmitterAt(e, p.RebuildHUDKeys, true)
+		//RebuildHUD_Keys:
+		//	#_0DFA88: STA.l $7EF36F
+		a.RTL()
+		a.WriteTextTo(e.Logger)
+	}
+
+	//e.LoggerCPU = os.Stdout
+
+	// run the initialization code:
+	if err = e.ExecAt(0x00_5000, h.DonePC); err != nil {
+		err = fmt.Errorf("alttp: initialize: %w", err)
+		return
+	}
+
+	return
+}
+
+func newEmitterAt(s *emulator.System, addr uint32, generateText bool) *asm.Emitter {
+	lin, _ := lorom.BusAddressToPak(addr)
+	a := asm.NewEmitter(s.ROM[lin:], generateText)
+	a.SetBase(addr)
+	return a
+}
@@ -0,0 +1,233 @@
+package alttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+// ApplyPatchFile applies an IPS or BPS patch file to a headerless ROM image and returns the patched image:
+func ApplyPatchFile(rom []byte, path string) (out []byte, err error) {
+	var p []byte
+	if p, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	switch {
+	case bytes.HasPrefix(p, []byte("PATCH")):
+		out, err = ApplyIPS(rom, p)
+	case bytes.HasPrefix(p, []byte("BPS1")):
+		out, err = ApplyBPS(rom, p)
+	default:
+		err = fmt.Errorf("unrecognized patch format; expected IPS or BPS")
+	}
+	if err != nil {
+		err = fmt.Errorf("patch: %s: %w", path, err)
+		return
+	}
+
+	if len(out) > 0x100_0000 {
+		err = fmt.Errorf("patch: %s: patched image is $%x bytes; larger than the 16MiB address space", path, len(out))
+		return
+	}
+	return
+}
+
+// ApplyIPS applies an IPS patch including the RLE records and the truncation extension:
+func ApplyIPS(src, p []byte) (out []byte, err error) {
+	out = append([]byte(nil), src...)
+
+	i := len("PATCH")
+	for {
+		if i+3 > len(p) {
+			err = fmt.Errorf("ips: missing EOF marker")
+			return
+		}
+		if string(p[i:i+3]) == "EOF" {
+			i += 3
+			break
+		}
+		if i+5 > len(p) {
+			err = fmt.Errorf("ips: truncated record at $%x", i)
+			return
+		}
+
+		offs := int(p[i])<<16 | int(p[i+1])<<8 | int(p[i+2])
+		size := int(p[i+3])<<8 | int(p[i+4])
+		i += 5
+
+		var data []byte
+		if size == 0 {
+			// RLE record:
+			if i+3 > len(p) {
+				err = fmt.Errorf("ips: truncated RLE record at $%x", i)
+				return
+			}
+			size = int(p[i])<<8 | int(p[i+1])
+			data = bytes.Repeat(p[i+2:i+3], size)
+			i += 3
+		} else {
+			if i+size > len(p) {
+				err = fmt.Errorf("ips: truncated record data at $%x", i)
+				return
+			}
+			data = p[i : i+size]
+			i += size
+		}
+
+		if end := offs + size; end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offs:], data)
+	}
+
+	// optional truncation extension:
+	if i+3 <= len(p) {
+		size := int(p[i])<<16 | int(p[i+1])<<8 | int(p[i+2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+	return
+}
+
+// ApplyBPS applies a BPS patch, verifying the source, target and patch CRC32s from its footer:
+func ApplyBPS(src, p []byte) (out []byte, err error) {
+	const footerSize = 12
+	if len(p) < len("BPS1")+footerSize {
+		err = fmt.Errorf("bps: patch too small")
+		return
+	}
+
+	footer := p[len(p)-footerSize:]
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:4])
+	targetCRC := binary.LittleEndian.Uint32(footer[4:8])
+	patchCRC := binary.LittleEndian.Uint32(footer[8:12])
+	if crc := crc32.ChecksumIEEE(p[:len(p)-4]); crc != patchCRC {
+		err = fmt.Errorf("bps: patch CRC32 %08x does not match %08x; corrupt patch", crc, patchCRC)
+		return
+	}
+
+	i := len("BPS1")
+	end := len(p) - footerSize
+	decode := func() (n uint64, err error) {
+		shift := uint64(1)
+		for {
+			if i >= end {
+				err = fmt.Errorf("bps: truncated number at $%x", i)
+				return
+			}
+			x := p[i]
+			i++
+			n += uint64(x&0x7F) * shift
+			if x&0x80 != 0 {
+				return
+			}
+			shift <<= 7
+			n += shift
+		}
+	}
+
+	var sourceSize, targetSize, metadataSize uint64
+	if sourceSize, err = decode(); err != nil {
+		return
+	}
+	if targetSize, err = decode(); err != nil {
+		return
+	}
+	if metadataSize, err = decode(); err != nil {
+		return
+	}
+	if metadataSize > uint64(end-i) {
+		err = fmt.Errorf("bps: metadata extends past end of patch")
+		return
+	}
+	i += int(metadataSize)
+
+	if uint64(len(src)) != sourceSize {
+		err = fmt.Errorf("bps: source ROM is $%x bytes but patch expects $%x; wrong base ROM", len(src), sourceSize)
+		return
+	}
+	if crc := crc32.ChecksumIEEE(src); crc != sourceCRC {
+		err = fmt.Errorf("bps: source ROM CRC32 %08x does not match %08x; wrong base ROM", crc, sourceCRC)
+		return
+	}
+	if targetSize > 0x100_0000 {
+		err = fmt.Errorf("bps: target size $%x is larger than the 16MiB address space", targetSize)
+		return
+	}
+
+	out = make([]byte, targetSize)
+	outOffs := 0
+	var sourceRel, targetRel int
+	for i < end {
+		var data uint64
+		if data, err = decode(); err != nil {
+			return
+		}
+		cmd := data & 3
+		length := int(data>>2) + 1
+		if outOffs+length > len(out) {
+			err = fmt.Errorf("bps: action at $%x writes past end of target", i)
+			return
+		}
+
+		switch cmd {
+		case 0: // SourceRead
+			if outOffs+length > len(src) {
+				err = fmt.Errorf("bps: source read at $%x past end of source", i)
+				return
+			}
+			copy(out[outOffs:], src[outOffs:outOffs+length])
+		case 1: // TargetRead
+			if i+length > end {
+				err = fmt.Errorf("bps: target read at $%x past end of patch", i)
+				return
+			}
+			copy(out[outOffs:], p[i:i+length])
+			i += length
+		case 2, 3: // SourceCopy, TargetCopy
+			var offs uint64
+			if offs, err = decode(); err != nil {
+				return
+			}
+			delta := int(offs >> 1)
+			if offs&1 != 0 {
+				delta = -delta
+			}
+			if cmd == 2 {
+				sourceRel += delta
+				if sourceRel < 0 || sourceRel+length > len(src) {
+					err = fmt.Errorf("bps: source copy at $%x out of range", i)
+					return
+				}
+				copy(out[outOffs:], src[sourceRel:sourceRel+length])
+				sourceRel += length
+			} else {
+				targetRel += delta
+				if targetRel < 0 || targetRel >= outOffs {
+					err = fmt.Errorf("bps: target copy at $%x out of range", i)
+					return
+				}
+				// byte by byte since the regions may overlap to repeat a pattern:
+				for n := 0; n < length; n++ {
+					out[outOffs+n] = out[targetRel]
+					targetRel++
+				}
+			}
+		}
+		outOffs += length
+	}
+
+	if outOffs != len(out) {
+		err = fmt.Errorf("bps: patch wrote $%x bytes but target is $%x bytes", outOffs, len(out))
+		return
+	}
+	if crc := crc32.ChecksumIEEE(out); crc != targetCRC {
+		err = fmt.Errorf("bps: patched ROM CRC32 %08x does not match %08x", crc, targetCRC)
+		return
+	}
+	return
+}
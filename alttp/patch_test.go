@@ -0,0 +1,183 @@
+package alttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestApplyIPS(t *testing.T) {
+	src := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    []byte
+		wantErr string
+	}{
+		{
+			name:  "record",
+			patch: "PATCH\x00\x00\x02\x00\x02\xAA\xBBEOF",
+			want:  []byte{0, 1, 0xAA, 0xBB, 4, 5, 6, 7},
+		},
+		{
+			name:  "RLE record",
+			patch: "PATCH\x00\x00\x01\x00\x00\x00\x03\xEEEOF",
+			want:  []byte{0, 0xEE, 0xEE, 0xEE, 4, 5, 6, 7},
+		},
+		{
+			name:  "record past the end grows the image",
+			patch: "PATCH\x00\x00\x0A\x00\x01\xCCEOF",
+			want:  []byte{0, 1, 2, 3, 4, 5, 6, 7, 0, 0, 0xCC},
+		},
+		{
+			name:  "truncation extension",
+			patch: "PATCH\x00\x00\x00\x00\x01\x99EOF\x00\x00\x04",
+			want:  []byte{0x99, 1, 2, 3},
+		},
+		{
+			name:  "truncation extension larger than the image",
+			patch: "PATCH\x00\x00\x00\x00\x01\x99EOF\x00\x01\x00",
+			want:  []byte{0x99, 1, 2, 3, 4, 5, 6, 7},
+		},
+		{
+			name:    "missing EOF",
+			patch:   "PATCH\x00\x00\x00\x00\x01\x99",
+			wantErr: "missing EOF",
+		},
+		{
+			name:    "truncated record header",
+			patch:   "PATCH\x00\x00\x00\x00",
+			wantErr: "truncated record",
+		},
+		{
+			name:    "truncated record data",
+			patch:   "PATCH\x00\x00\x00\x00\x04\x99",
+			wantErr: "truncated record data",
+		},
+		{
+			name:    "truncated RLE record",
+			patch:   "PATCH\x00\x00\x00\x00\x00\x00",
+			wantErr: "truncated RLE record",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyIPS(src, []byte(tt.patch))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % 02x; want % 02x", got, tt.want)
+			}
+		})
+	}
+
+	if !bytes.Equal(src, []byte{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("source modified: % 02x", src)
+	}
+}
+
+// bpsNumber encodes n as a BPS variable-length number:
+func bpsNumber(n uint64) (b []byte) {
+	for {
+		x := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(b, 0x80|x)
+		}
+		b = append(b, x)
+		n--
+	}
+}
+
+// bpsPatch builds a BPS patch from its actions, with the footer CRC32s of src and target:
+func bpsPatch(src, target []byte, actions ...[]byte) []byte {
+	p := []byte("BPS1")
+	p = append(p, bpsNumber(uint64(len(src)))...)
+	p = append(p, bpsNumber(uint64(len(target)))...)
+	p = append(p, bpsNumber(0)...)
+	for _, a := range actions {
+		p = append(p, a...)
+	}
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(src))
+	p = append(p, crc[:]...)
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(target))
+	p = append(p, crc[:]...)
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(p))
+	return append(p, crc[:]...)
+}
+
+func TestApplyBPS(t *testing.T) {
+	src := []byte{0x10, 0x11, 0x12, 0x13, 0x14, 0x15}
+
+	// action is length-1 << 2 | command:
+	sourceRead := func(n int) []byte { return bpsNumber(uint64(n-1) << 2) }
+	targetRead := func(data ...byte) []byte { return append(bpsNumber(uint64(len(data)-1)<<2|1), data...) }
+	copyAction := func(cmd uint64, n int, delta int) []byte {
+		offs := uint64(delta) << 1
+		if delta < 0 {
+			offs = uint64(-delta)<<1 | 1
+		}
+		return append(bpsNumber(uint64(n-1)<<2|cmd), bpsNumber(offs)...)
+	}
+
+	target := []byte{0x10, 0x11, 0xAA, 0xBB, 0xAA, 0xBB, 0xAA, 0x14, 0x15}
+	valid := bpsPatch(src, target,
+		sourceRead(2),
+		targetRead(0xAA, 0xBB),
+		copyAction(3, 3, 2), // TargetCopy repeating the two bytes just read
+		copyAction(2, 2, 4), // SourceCopy of $14 $15
+	)
+
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len("BPS1")+4] ^= 0xFF
+
+	wrongTarget := bpsPatch(src, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0},
+		sourceRead(2),
+		targetRead(0xAA, 0xBB),
+		copyAction(3, 3, 2),
+		copyAction(2, 2, 4),
+	)
+
+	tests := []struct {
+		name    string
+		src     []byte
+		patch   []byte
+		want    []byte
+		wantErr string
+	}{
+		{name: "all actions", src: src, patch: valid, want: target},
+		{name: "corrupt patch", src: src, patch: corrupt, wantErr: "patch CRC32"},
+		{name: "wrong source size", src: src[:5], patch: valid, wantErr: "wrong base ROM"},
+		{name: "wrong source CRC", src: []byte{0, 0, 0, 0, 0, 0}, patch: valid, wantErr: "source ROM CRC32"},
+		{name: "wrong target CRC", src: src, patch: wrongTarget, wantErr: "patched ROM CRC32"},
+		{name: "too small", src: src, patch: []byte("BPS1"), wantErr: "too small"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyBPS(tt.src, tt.patch)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % 02x; want % 02x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package alttp
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/snes/mapping/lorom"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ROMProfile holds every ROM address the harness calls into, patches or hooks for one game revision.
+// Addresses are full 24-bit bus addresses even where only the low 16 bits are emitted (JSR/JMP).
+// Each address is found in a disassembly's symbol file by its `sym` tag: a label, a label plus a hex offset, or,
+// with an `at` or `after` tag, the first occurrence of that instruction in the routine at the label. WithSymbols
+// relocates a profile by them and ProfileFromSymbols builds a whole profile from them.
+type ROMProfile struct {
+	Version ROMVersion
+
+	// Reset routine stops here, before JSR Sound_LoadIntroSongBank:
+	ResetStop uint32 `sym:"Reset+$29"`
+
+	ModuleMainRouting          uint32 `sym:"Module_MainRouting"`                                                       // Module_MainRouting#_0080B5
+	NMIReadJoypads             uint32 `sym:"NMI_ReadJoypads"`                                                          // NMI_ReadJoypads#_0083D1
+	NMIPrepareSprites          uint32 `sym:"NMI_PrepareSprites"`                                                       // NMI_PrepareSprites#_0085FC
+	NMIDoUpdates               uint32 `sym:"NMI_DoUpdates"`                                                            // NMI_DoUpdates#_0089E0
+	RoomsWithPitDamage         uint32 `sym:"RoomsWithPitDamage"`                                                       // RoomsWithPitDamage#_00990C [0x70]uint16
+	UnderworldLoadRoom         uint32 `sym:"Underworld_LoadRoom"`                                                      // Underworld_LoadRoom#_01873A
+	UnderworldLoadAttributes   uint32 `sym:"Underworld_LoadAttributeTable"`                                            // Underworld_LoadAttributeTable#_01B8BF
+	UnderworldHandleRoomTags   uint32 `sym:"Underworld_HandleRoomTags"`                                                // Underworld_HandleRoomTags#_01C2FD
+	Module06AfterLoadEntrance  uint32 `sym:"Module06_UnderworldLoad" after:"JSR Underworld_LoadEntrance"`              // #_028157
+	EntranceRooms              uint32 `sym:"EntranceData_room"`                                                        // #_02C577 [0x85]uint16; written to $A0
+	DoPotsBlocksTorches        uint32 `sym:"Underworld_LoadEntrance_DoPotsBlocksTorches" at:"PHB"`                     // #_02D854
+	LoadSongBankIfNeededCall   uint32 `sym:"Module06_UnderworldLoad" at:"JSR Underworld_LoadSongBankIfNeeded"`         // #_028293
+	LoadSongBankIfNeededExit   uint32 `sym:"Underworld_LoadSongBankIfNeeded" at:"SEP #$20; RTL"`                       // .exit#_0282BC
+	InitializeDefaultGFX       uint32 `sym:"Intro_InitializeDefaultGFX" after:"JSL DecompressAnimatedUnderworldTiles"` // #_0CC237
+	InitializeTriforceIntro    uint32 `sym:"InitializeTriforceIntro"`                                                  // InitializeTriforceIntro#_0CF03B
+	RebuildHUDKeys             uint32 `sym:"RebuildHUD_Keys"`                                                          // RebuildHUD_Keys#_0DFA88
+	LoadDefaultTileAttributes  uint32 `sym:"LoadDefaultTileAttributes"`                                                // LoadDefaultTileAttributes#_0FFD2A
+	LoadCustomTileAttributes   uint32 `sym:"Underworld_LoadCustomTileAttributes"`                                      // Underworld_LoadCustomTileAttributes#_0FFD65
+	RoomDrawAfterAllObjects    uint32 `sym:"Underworld_LoadRoom+$FD"`                                                  // #_018837 after JSR RoomDraw_DrawAllObjects
+	RoomDrawLayer2             uint32 `sym:"Underworld_LoadRoom+$125"`                                                 // #_01885F start of layer 2 object drawing
+	RoomDrawLayer3             uint32 `sym:"Underworld_LoadRoom+$13A"`                                                 // #_018874 start of layer 3 (doors) object drawing
+	RoomDrawAfterFloors        uint32 `sym:"Underworld_LoadRoom+$D8"`                                                  // #_018812 after JSR RoomDraw_DrawFloors
+	RoomDrawMany32x32BlocksRTS uint32 `sym:"RoomDraw_A_Many32x32Blocks+$44"`                                           // #_018A88 RTS
+	RoomDrawAfterObject        uint32 `sym:"Underworld_LoadRoom+$1C1"`                                                 // #_0188FB after JSR RoomData_DrawObject
+	RoomDrawAfterDoor          uint32 `sym:"Underworld_LoadRoom+$1D6"`                                                 // #_018910 after JSR RoomData_DrawObject_Door
+}
+
+var profileJP10 = ROMProfile{
+	Version:                    VersionJP10,
+	ResetStop:                  0x00_8029,
+	ModuleMainRouting:          0x00_80B5,
+	NMIReadJoypads:             0x00_83D1,
+	NMIPrepareSprites:          0x00_85FC,
+	NMIDoUpdates:               0x00_89E0,
+	RoomsWithPitDamage:         0x00_990C,
+	UnderworldLoadRoom:         0x01_873A,
+	UnderworldLoadAttributes:   0x01_B8BF,
+	UnderworldHandleRoomTags:   0x01_C2FD,
+	Module06AfterLoadEntrance:  0x02_8157,
+	EntranceRooms:              0x02_C577,
+	DoPotsBlocksTorches:        0x02_D854,
+	LoadSongBankIfNeededCall:   0x02_8293,
+	LoadSongBankIfNeededExit:   0x02_82BC,
+	InitializeDefaultGFX:       0x0C_C237,
+	InitializeTriforceIntro:    0x0C_F03B,
+	RebuildHUDKeys:             0x0D_FA88,
+	LoadDefaultTileAttributes:  0x0F_FD2A,
+	LoadCustomTileAttributes:   0x0F_FD65,
+	RoomDrawAfterAllObjects:    0x01_8837,
+	RoomDrawLayer2:             0x01_885F,
+	RoomDrawLayer3:             0x01_8874,
+	RoomDrawAfterFloors:        0x01_8812,
+	RoomDrawMany32x32BlocksRTS: 0x01_8A88,
+	RoomDrawAfterObject:        0x01_88FB,
+	RoomDrawAfterDoor:          0x01_8910,
+}
+
+// only JP 1.0 addresses are known; the other revisions need a symbol file with every profile label:
+var romProfiles = map[ROMVersion]*ROMProfile{
+	VersionJP10: &profileJP10,
+}
+
+// profileSite is how a profile address field is found in a symbol file: the address of label plus offset, or,
+// with instr, the address of the first occurrence of instr in the routine at label, or the one following it when
+// after is set:
+type profileSite struct {
+	field  string
+	label  string
+	offset uint32
+	instr  string
+	after  bool
+}
+
+// profileSiteSearchLen bounds how far into a routine an instruction is searched for:
+const profileSiteSearchLen = 0x400
+
+// profileSiteOf returns the site of a profile address field; ok is false for fields that are not addresses:
+func profileSiteOf(f reflect.StructField) (s profileSite, ok bool, err error) {
+	if f.Type.Kind() != reflect.Uint32 {
+		return
+	}
+
+	s.field = f.Name
+	if s.label = f.Tag.Get("sym"); s.label == "" {
+		err = fmt.Errorf("profile: %s has no sym tag", f.Name)
+		return
+	}
+	if i := strings.IndexByte(s.label, '+'); i >= 0 {
+		var off uint64
+		if off, err = strconv.ParseUint(strings.TrimPrefix(s.label[i+1:], "$"), 16, 16); err != nil {
+			err = fmt.Errorf("profile: %s: bad offset in %q: %w", f.Name, s.label, err)
+			return
+		}
+		s.label, s.offset = s.label[:i], uint32(off)
+	}
+	if s.instr = f.Tag.Get("at"); s.instr == "" {
+		s.instr, s.after = f.Tag.Get("after"), true
+	}
+	if s.instr == "" {
+		s.after = false
+	}
+	ok = true
+	return
+}
+
+// String formats the site as it is tagged, e.g. "Underworld_LoadRoom+$FD":
+func (s profileSite) String() string {
+	switch {
+	case s.instr != "" && s.after:
+		return fmt.Sprintf("%s after %s", s.label, s.instr)
+	case s.instr != "":
+		return fmt.Sprintf("%s at %s", s.label, s.instr)
+	case s.offset != 0:
+		return fmt.Sprintf("%s+$%X", s.label, s.offset)
+	default:
+		return s.label
+	}
+}
+
+// symbol names the site's address in traces; hook sites inside a routine go by their field name:
+func (s profileSite) symbol() string {
+	if s.instr != "" || s.offset != 0 {
+		return s.field
+	}
+	return s.label
+}
+
+// labels lists the labels syms must name to resolve the site:
+func (s profileSite) labels() (labels []string) {
+	labels = append(labels, s.label)
+	for _, in := range strings.Split(s.instr, ";") {
+		if f := strings.Fields(in); len(f) == 2 && (f[0] == "JSR" || f[0] == "JSL") {
+			labels = append(labels, f[1])
+		}
+	}
+	return
+}
+
+// assemble encodes the few instructions sites are searched by, taking JSR and JSL targets from syms:
+func (s profileSite) assemble(syms *emulator.Symbols) (code []byte, err error) {
+	for _, in := range strings.Split(s.instr, ";") {
+		f := strings.Fields(in)
+		switch {
+		case len(f) == 1 && f[0] == "PHB":
+			code = append(code, 0x8B)
+		case len(f) == 1 && f[0] == "RTS":
+			code = append(code, 0x60)
+		case len(f) == 1 && f[0] == "RTL":
+			code = append(code, 0x6B)
+		case len(f) == 2 && (f[0] == "SEP" || f[0] == "REP") && strings.HasPrefix(f[1], "#$"):
+			var imm uint64
+			if imm, err = strconv.ParseUint(f[1][2:], 16, 8); err != nil {
+				return
+			}
+			code = append(code, map[string]byte{"SEP": 0xE2, "REP": 0xC2}[f[0]], byte(imm))
+		case len(f) == 2 && (f[0] == "JSR" || f[0] == "JSL"):
+			target, _ := syms.Addr(f[1])
+			if f[0] == "JSR" {
+				code = append(code, 0x20, byte(target), byte(target>>8))
+			} else {
+				code = append(code, 0x22, byte(target), byte(target>>8), byte(target>>16))
+			}
+		default:
+			err = fmt.Errorf("unsupported instruction %q", strings.TrimSpace(in))
+			return
+		}
+	}
+	return
+}
+
+// resolve finds the site's address with syms, searching rom for its instruction; ok is false when syms lacks one
+// of the site's labels:
+func (s profileSite) resolve(syms *emulator.Symbols, rom []byte) (addr uint32, ok bool, err error) {
+	for _, label := range s.labels() {
+		if _, ok = syms.Addr(label); !ok {
+			return
+		}
+	}
+
+	addr, _ = syms.Addr(s.label)
+	addr += s.offset
+	if s.instr == "" {
+		return
+	}
+
+	var code []byte
+	if code, err = s.assemble(syms); err != nil {
+		err = fmt.Errorf("profile: %s: %w", s, err)
+		return
+	}
+
+	// search the routine up to the end of its bank:
+	var lin uint32
+	if lin, err = lorom.BusAddressToPak(addr); err != nil {
+		err = fmt.Errorf("profile: %s: %w", s, err)
+		return
+	}
+	end := lin + profileSiteSearchLen
+	if bankEnd := lin + 0x1_0000 - addr&0xFFFF; end > bankEnd {
+		end = bankEnd
+	}
+	if end > uint32(len(rom)) {
+		end = uint32(len(rom))
+	}
+	if lin >= end {
+		err = fmt.Errorf("profile: %s: $%06x is outside the ROM", s, addr)
+		return
+	}
+
+	i := bytes.Index(rom[lin:end], code)
+	if i < 0 {
+		err = fmt.Errorf("profile: %s: instruction not found within $%x bytes of $%06x", s, profileSiteSearchLen, addr)
+		return
+	}
+	addr += uint32(i)
+	if s.after {
+		addr += uint32(len(code))
+	}
+	return
+}
+
+// profileSites calls fn with the value and site of each address field of p:
+func profileSites(p *ROMProfile, fn func(v reflect.Value, s profileSite) error) (err error) {
+	v := reflect.ValueOf(p).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		var s profileSite
+		var ok bool
+		if s, ok, err = profileSiteOf(v.Type().Field(i)); err != nil {
+			return
+		}
+		if !ok {
+			continue
+		}
+		if err = fn(v.Field(i), s); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// addSymbols names each address in the profile by its label:
+func (p *ROMProfile) addSymbols(s *emulator.Symbols) {
+	_ = profileSites(p, func(v reflect.Value, site profileSite) error {
+		s.Add(uint32(v.Uint()), site.symbol())
+		return nil
+	})
+}
+
+// WithSymbols returns a copy of the profile with the address of each site that syms names replaced by the one
+// found with syms in rom, so that ROM hacks which move routines work without code changes. relocated describes
+// the sites whose address changed:
+func (p *ROMProfile) WithSymbols(syms *emulator.Symbols, rom []byte) (q *ROMProfile, relocated []string, err error) {
+	c := *p
+	q = &c
+
+	err = profileSites(q, func(v reflect.Value, s profileSite) (err error) {
+		addr, ok, err := s.resolve(syms, rom)
+		if err != nil || !ok {
+			return
+		}
+		if uint32(v.Uint()) != addr {
+			v.SetUint(uint64(addr))
+			relocated = append(relocated, fmt.Sprintf("%s to $%06x", s, addr))
+		}
+		return
+	})
+	if err != nil {
+		q, relocated = nil, nil
+	}
+	return
+}
+
+// ProfileFor returns the built-in address profile of a ROM revision:
+func ProfileFor(v ROMVersion) (p *ROMProfile, err error) {
+	var ok bool
+	if p, ok = romProfiles[v]; !ok {
+		err = fmt.Errorf("profile: unsupported revision %s; pass -symbols with a symbol file naming every profile label", v)
+	}
+	return
+}
+
+// ProfileFromSymbols builds the profile of a ROM revision without a built-in one from a symbol file, which
+// must name every label of the profile's sites, searching rom for the sites found by instruction:
+func ProfileFromSymbols(v ROMVersion, syms *emulator.Symbols, rom []byte) (p *ROMProfile, err error) {
+	p = &ROMProfile{Version: v}
+
+	var missing []string
+	seen := make(map[string]bool)
+	err = profileSites(p, func(f reflect.Value, s profileSite) (err error) {
+		addr, ok, err := s.resolve(syms, rom)
+		if err != nil {
+			return
+		}
+		if ok {
+			f.SetUint(uint64(addr))
+			return
+		}
+		for _, label := range s.labels() {
+			if _, found := syms.Addr(label); !found && !seen[label] {
+				seen[label] = true
+				missing = append(missing, label)
+			}
+		}
+		return
+	})
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("profile: unsupported revision %s and symbols lack %s", v, strings.Join(missing, ", "))
+	}
+	if err != nil {
+		p = nil
+	}
+	return
+}
+
+// profileCheck is an instruction the harness expects at a profile address:
+type profileCheck struct {
+	name   string
+	addr   uint32
+	expect []byte
+}
+
+// Verify checks the instruction bytes at the addresses we patch, hook or stop at so that a profile
+// which does not fit the ROM is rejected up front. patchSongs checks the song bank loading patch sites too:
+func (p *ROMProfile) Verify(rom []byte, patchSongs bool) (err error) {
+	checks := []profileCheck{
+		{"JSR Sound_LoadIntroSongBank", p.ResetStop, []byte{0x20}},
+		{"RebuildHUD_Keys", p.RebuildHUDKeys, []byte{0x8F, 0x6F, 0xF3, 0x7E}},
+		{"JSR Underworld_LoadEntrance", p.Module06AfterLoadEntrance - 3, []byte{0x20}},
+		{"Underworld_LoadEntrance_DoPotsBlocksTorches PHB", p.DoPotsBlocksTorches, []byte{0x8B}},
+		{"JSL DecompressAnimatedUnderworldTiles", p.InitializeDefaultGFX - 4, []byte{0x22}},
+		{"JSR RoomDraw_DrawAllObjects", p.RoomDrawAfterAllObjects - 3, []byte{0x20}},
+		{"JSR RoomDraw_DrawFloors", p.RoomDrawAfterFloors - 3, []byte{0x20}},
+		{"RoomDraw_A_Many32x32Blocks RTS", p.RoomDrawMany32x32BlocksRTS, []byte{0x60}},
+		{"JSR RoomData_DrawObject", p.RoomDrawAfterObject - 3, []byte{0x20}},
+		{"JSR RoomData_DrawObject_Door", p.RoomDrawAfterDoor - 3, []byte{0x20}},
+	}
+	if patchSongs {
+		checks = append(checks, []profileCheck{
+			{"JSR Underworld_LoadSongBankIfNeeded", p.LoadSongBankIfNeededCall, []byte{0x20}},
+			{"Underworld_LoadSongBankIfNeeded .exit", p.LoadSongBankIfNeededExit, []byte{0xE2, 0x20, 0x6B}},
+		}...)
+	}
+
+	for _, c := range checks {
+		var lin uint32
+		if lin, err = lorom.BusAddressToPak(c.addr); err != nil {
+			err = fmt.Errorf("profile: %s: %s at $%06x: %w", p.Version, c.name, c.addr, err)
+			return
+		}
+		if end := uint64(lin) + uint64(len(c.expect)); end > uint64(len(rom)) {
+			err = fmt.Errorf(
+				"profile: %s: %s at $%06x is outside the %d byte ROM",
+				p.Version,
+				c.name,
+				c.addr,
+				len(rom),
+			)
+			return
+		}
+		if actual := rom[lin : lin+uint32(len(c.expect))]; !bytes.Equal(actual, c.expect) {
+			err = fmt.Errorf(
+				"profile: %s: expected % x at $%06x for %s but found % x",
+				p.Version,
+				c.expect,
+				c.addr,
+				c.name,
+				actual,
+			)
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,158 @@
+package alttp
+
+import (
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/snes/mapping/lorom"
+	"strings"
+	"testing"
+)
+
+// jp10Sites are the labels and instructions of the JP 1.0 ROM around the profile's sites; the labels of routines
+// the harness only enters mid-way are placed arbitrarily before them:
+var jp10Sites = []struct {
+	label string
+	addr  uint32
+	code  []byte
+}{
+	{"Reset", 0x00_8000, nil},
+	{"", 0x00_8029, []byte{0x20, 0x00, 0x90}},
+	{"Module_MainRouting", 0x00_80B5, nil},
+	{"NMI_ReadJoypads", 0x00_83D1, nil},
+	{"NMI_PrepareSprites", 0x00_85FC, nil},
+	{"NMI_DoUpdates", 0x00_89E0, nil},
+	{"RoomsWithPitDamage", 0x00_990C, nil},
+	{"Underworld_LoadRoom", 0x01_873A, nil},
+	{"RoomDraw_DrawFloors", 0x01_8900, nil},
+	{"", 0x01_880F, []byte{0x20, 0x00, 0x89}},
+	{"", 0x01_8834, []byte{0x20, 0x00, 0x8C}},
+	{"", 0x01_88F8, []byte{0x20, 0x00, 0x8D}},
+	{"", 0x01_890D, []byte{0x20, 0x00, 0x8E}},
+	{"RoomDraw_A_Many32x32Blocks", 0x01_8A44, nil},
+	{"", 0x01_8A88, []byte{0x60}},
+	{"Underworld_LoadAttributeTable", 0x01_B8BF, nil},
+	{"Underworld_HandleRoomTags", 0x01_C2FD, nil},
+	{"Module06_UnderworldLoad", 0x02_8100, nil},
+	{"Underworld_LoadEntrance", 0x02_D800, nil},
+	{"", 0x02_8154, []byte{0x20, 0x00, 0xD8}},
+	{"Underworld_LoadSongBankIfNeeded", 0x02_82A0, nil},
+	{"", 0x02_8293, []byte{0x20, 0xA0, 0x82}},
+	{"", 0x02_82BC, []byte{0xE2, 0x20, 0x6B}},
+	{"EntranceData_room", 0x02_C577, nil},
+	{"Underworld_LoadEntrance_DoPotsBlocksTorches", 0x02_D850, nil},
+	{"", 0x02_D854, []byte{0x8B}},
+	{"Intro_InitializeDefaultGFX", 0x0C_C200, nil},
+	{"DecompressAnimatedUnderworldTiles", 0x00_E000, nil},
+	{"", 0x0C_C233, []byte{0x22, 0x00, 0xE0, 0x00}},
+	{"InitializeTriforceIntro", 0x0C_F03B, nil},
+	{"RebuildHUD_Keys", 0x0D_FA88, []byte{0x8F, 0x6F, 0xF3, 0x7E}},
+	{"LoadDefaultTileAttributes", 0x0F_FD2A, nil},
+	{"Underworld_LoadCustomTileAttributes", 0x0F_FD65, nil},
+	{"RoomDraw_DrawAllObjects", 0x01_8C00, nil},
+	{"RoomData_DrawObject", 0x01_8D00, nil},
+	{"RoomData_DrawObject_Door", 0x01_8E00, nil},
+}
+
+func jp10SymbolsAndROM(t *testing.T) (syms *emulator.Symbols, rom []byte) {
+	syms = emulator.NewSymbols()
+	rom = make([]byte, 0x10_0000)
+	for _, s := range jp10Sites {
+		if s.label != "" {
+			syms.Add(s.addr, s.label)
+		}
+		lin, err := lorom.BusAddressToPak(s.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(rom[lin:], s.code)
+	}
+	return
+}
+
+func TestProfileFromSymbols(t *testing.T) {
+	syms, rom := jp10SymbolsAndROM(t)
+
+	p, err := ProfileFromSymbols(VersionJP10, syms, rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != profileJP10 {
+		t.Errorf("got %+v\nwant %+v", *p, profileJP10)
+	}
+	if err = p.Verify(rom, true); err != nil {
+		t.Error(err)
+	}
+
+	q, relocated, err := profileJP10.WithSymbols(syms, rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *q != profileJP10 || len(relocated) != 0 {
+		t.Errorf("relocated %v", relocated)
+	}
+}
+
+func TestProfileFromSymbolsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		drop    string // label removed from the symbols
+		code    uint32 // address whose code is cleared
+		wantErr string
+	}{
+		{"missing label", "Underworld_LoadRoom", 0, "symbols lack Underworld_LoadRoom"},
+		{"missing JSR target", "Underworld_LoadEntrance", 0, "symbols lack Underworld_LoadEntrance"},
+		{"instruction not found", "", 0x02_8154, "instruction not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			full, rom := jp10SymbolsAndROM(t)
+			syms := emulator.NewSymbols()
+			for _, s := range jp10Sites {
+				if addr, ok := full.Addr(s.label); ok && s.label != tt.drop {
+					syms.Add(addr, s.label)
+				}
+			}
+			if tt.code != 0 {
+				lin, _ := lorom.BusAddressToPak(tt.code)
+				copy(rom[lin:], []byte{0, 0, 0, 0})
+			}
+
+			_, err := ProfileFromSymbols(VersionUS, syms, rom)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfileVerify(t *testing.T) {
+	_, rom := jp10SymbolsAndROM(t)
+
+	tests := []struct {
+		name    string
+		modify  func(p *ROMProfile)
+		rom     []byte
+		wantErr string
+	}{
+		{"ok", func(p *ROMProfile) {}, rom, ""},
+		{"short ROM", func(p *ROMProfile) {}, rom[:0x8000], "outside the"},
+		{"address outside ROM", func(p *ROMProfile) { p.RebuildHUDKeys = 0x3F_FFFE }, rom, "outside the"},
+		{"not ROM", func(p *ROMProfile) { p.ResetStop = 0x7E_0000 }, rom, "JSR Sound_LoadIntroSongBank"},
+		{"wrong bytes", func(p *ROMProfile) { p.DoPotsBlocksTorches++ }, rom, "expected 8b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := profileJP10
+			tt.modify(&p)
+			err := p.Verify(tt.rom, false)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
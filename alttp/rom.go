@@ -0,0 +1,171 @@
+// Package alttp loads A Link to the Past ROM images, applies romhack patches and sets up an emulated system
+// with small harness routines that call into the game to load entrances and supertiles.
+package alttp
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alttpo/snes"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type ROMVersion uint8
+
+const (
+	VersionUnknown ROMVersion = iota
+	VersionJP10
+	VersionJP11
+	VersionJP12
+	VersionUS
+	VersionEU
+)
+
+func (v ROMVersion) String() string {
+	switch v {
+	case VersionJP10:
+		return "JP 1.0"
+	case VersionJP11:
+		return "JP 1.1"
+	case VersionJP12:
+		return "JP 1.2"
+	case VersionUS:
+		return "US 1.0"
+	case VersionEU:
+		return "EU 1.0"
+	}
+	return "unknown"
+}
+
+// Set parses a revision as the -version flag takes it, e.g. "jp1.0", "us" or "eu":
+func (v *ROMVersion) Set(s string) error {
+	switch strings.ToLower(strings.ReplaceAll(s, " ", "")) {
+	case "jp", "jp1.0", "jp10":
+		*v = VersionJP10
+	case "jp1.1", "jp11":
+		*v = VersionJP11
+	case "jp1.2", "jp12":
+		*v = VersionJP12
+	case "us", "us1.0", "us10":
+		*v = VersionUS
+	case "eu", "eu1.0", "eu10":
+		*v = VersionEU
+	default:
+		return fmt.Errorf("unknown ROM revision %q; expected jp1.0, jp1.1, jp1.2, us or eu", s)
+	}
+	return nil
+}
+
+const (
+	copierHeaderSize = 0x200
+	loromHeaderAddr  = 0x7FB0
+)
+
+// LoadROM reads a LoROM ALTTP image from disk, strips any copier header, validates the internal
+// header and identifies the region/revision of the game. override, unless VersionUnknown, is taken as the
+// revision instead, for randomizer and pre-patched images whose header no longer identifies them; a checksum
+// that does not match, as is usual for those, is only reported to logger:
+func LoadROM(path string, override ROMVersion, logger io.Writer) (rom *snes.ROM, version ROMVersion, err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	// strip 512-byte copier header (e.g. .smc dumps):
+	if len(contents)&0x7FFF == copierHeaderSize {
+		contents = contents[copierHeaderSize:]
+	}
+	if len(contents)&0x7FFF != 0 {
+		err = fmt.Errorf("rom: %s: size $%x is not a multiple of $8000; truncated or corrupt image", path, len(contents))
+		return
+	}
+	if len(contents) < loromHeaderAddr+0x50 {
+		err = fmt.Errorf("rom: %s: too small to contain a LoROM header", path)
+		return
+	}
+
+	if rom, err = snes.NewROM(path, contents); err != nil {
+		err = fmt.Errorf("rom: %s: %w", path, err)
+		return
+	}
+
+	h := &rom.Header
+	if h.MapMode&^0x10 != 0x20 {
+		err = fmt.Errorf("rom: %s: map mode $%02x is not LoROM", path, h.MapMode)
+		return
+	}
+	if expected := h.ROMSizeBytes(); uint32(len(contents)) < expected {
+		err = fmt.Errorf("rom: %s: image is $%x bytes but header declares $%x; truncated image", path, len(contents), expected)
+		return
+	}
+	if logger != nil {
+		if h.CheckSum^h.ComplementCheckSum != 0xFFFF {
+			fmt.Fprintf(logger, "rom: %s: warning: header checksum $%04x and complement $%04x do not match\n", path, h.CheckSum, h.ComplementCheckSum)
+		} else if sum := Checksum(contents); sum != h.CheckSum {
+			fmt.Fprintf(logger, "rom: %s: warning: computed checksum $%04x does not match header checksum $%04x; modified image?\n", path, sum, h.CheckSum)
+		}
+	}
+
+	if version = override; version != VersionUnknown {
+		return
+	}
+	if version = IdentifyROM(h); version == VersionUnknown {
+		err = fmt.Errorf(
+			"rom: %s: unrecognized image %q (region $%02x, revision $%02x); expected an ALTTP JP, US or EU ROM, or pass -version for a randomizer or pre-patched one",
+			path,
+			strings.TrimRight(string(h.Title[:]), " \x00"),
+			uint8(h.DestinationCode),
+			h.MaskROMVersion,
+		)
+		return
+	}
+
+	return
+}
+
+func IdentifyROM(h *snes.Header) ROMVersion {
+	title := string(bytes.TrimRight(h.Title[:], " \x00"))
+	switch {
+	case title == "ZELDANODENSETSU" && h.DestinationCode == snes.RegionJapan:
+		switch h.MaskROMVersion {
+		case 0:
+			return VersionJP10
+		case 1:
+			return VersionJP11
+		case 2:
+			return VersionJP12
+		}
+	case title == "THE LEGEND OF ZELDA" && h.DestinationCode == snes.RegionNorthAmerica:
+		if h.MaskROMVersion == 0 {
+			return VersionUS
+		}
+	case title == "THE LEGEND OF ZELDA" && h.DestinationCode == snes.RegionEurope:
+		if h.MaskROMVersion == 0 {
+			return VersionEU
+		}
+	}
+	return VersionUnknown
+}
+
+// Checksum computes the SNES internal checksum; images whose size is not a power of two have
+// their remainder mirrored up to the next power of two as the hardware would see it:
+func Checksum(contents []byte) (sum uint16) {
+	size := len(contents)
+	base := 1
+	for base<<1 <= size {
+		base <<= 1
+	}
+
+	for _, b := range contents[:base] {
+		sum += uint16(b)
+	}
+
+	if rem := contents[base:]; len(rem) > 0 {
+		// mirror the remaining part until it fills the same size as the base part:
+		for i := 0; i < base; i++ {
+			sum += uint16(rem[i%len(rem)])
+		}
+	}
+	return
+}
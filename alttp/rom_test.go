@@ -0,0 +1,133 @@
+package alttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents []byte
+		want     uint16
+	}{
+		{"power of two", []byte{1, 2, 3, 4}, 10},
+		{"remainder mirrored", []byte{1, 2, 3, 4, 5, 6}, 1 + 2 + 3 + 4 + 5 + 6 + 5 + 6},
+		{"wraps", bytes.Repeat([]byte{0xFF}, 0x200), 0xFE00},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Checksum(tt.contents); got != tt.want {
+				t.Errorf("got $%04x; want $%04x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadROM(t *testing.T) {
+	// image builds a 256KiB LoROM image with a header for title and region and a valid checksum:
+	image := func(title string, region, mask byte) []byte {
+		rom := make([]byte, 0x4_0000)
+		for i := range rom {
+			rom[i] = byte(i * 7)
+		}
+		h := rom[0x7FB0:0x8000]
+		copy(h[0x10:0x25], title+strings.Repeat(" ", 21-len(title)))
+		h[0x25] = 0x20 // LoROM
+		h[0x27] = 8    // 256KiB
+		h[0x29] = region
+		h[0x2A] = 0x01
+		h[0x2B] = mask
+		binary.LittleEndian.PutUint16(h[0x2C:], 0xFFFF)
+		binary.LittleEndian.PutUint16(h[0x2E:], 0x0000)
+		sum := Checksum(rom)
+		binary.LittleEndian.PutUint16(h[0x2C:], ^sum)
+		binary.LittleEndian.PutUint16(h[0x2E:], sum)
+		return rom
+	}
+	jp := image("ZELDANODENSETSU", 0, 0)
+	badSum := append([]byte(nil), jp...)
+	badSum[0x1234]++
+
+	tests := []struct {
+		name     string
+		contents []byte
+		override ROMVersion
+		want     ROMVersion
+		wantLog  string
+		wantErr  string
+	}{
+		{name: "JP 1.0", contents: jp, want: VersionJP10},
+		{name: "JP 1.2", contents: image("ZELDANODENSETSU", 0, 2), want: VersionJP12},
+		{name: "US", contents: image("THE LEGEND OF ZELDA", 1, 0), want: VersionUS},
+		{name: "EU", contents: image("THE LEGEND OF ZELDA", 2, 0), want: VersionEU},
+		{name: "copier header", contents: append(make([]byte, 0x200), jp...), want: VersionJP10},
+		{name: "checksum mismatch warns", contents: badSum, want: VersionJP10, wantLog: "warning: computed checksum"},
+		{name: "truncated", contents: jp[:0x3_0000+1], wantErr: "not a multiple of $8000"},
+		{name: "header declares more", contents: jp[:0x2_0000], wantErr: "truncated image"},
+		{name: "unrecognized title", contents: image("VT TOURNEY", 0, 0), wantErr: "pass -version"},
+		{name: "override", contents: image("VT TOURNEY", 0, 0), override: VersionJP10, want: VersionJP10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rom.sfc")
+			if err := ioutil.WriteFile(path, tt.contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var log bytes.Buffer
+			rom, version, err := LoadROM(path, tt.override, &log)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.want {
+				t.Errorf("got version %s; want %s", version, tt.want)
+			}
+			if len(rom.Contents) != 0x4_0000 {
+				t.Errorf("got $%x bytes; want $40000", len(rom.Contents))
+			}
+			if tt.wantLog == "" && log.Len() != 0 {
+				t.Errorf("unexpected log %q", log.String())
+			} else if !strings.Contains(log.String(), tt.wantLog) {
+				t.Errorf("got log %q; want %q", log.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestROMVersionSet(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    ROMVersion
+		wantErr bool
+	}{
+		{"jp1.0", VersionJP10, false},
+		{"JP 1.1", VersionJP11, false},
+		{"jp12", VersionJP12, false},
+		{"us", VersionUS, false},
+		{"EU", VersionEU, false},
+		{"jp2", VersionUnknown, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			var v ROMVersion
+			err := v.Set(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if v != tt.want {
+				t.Errorf("got %s; want %s", v, tt.want)
+			}
+		})
+	}
+}
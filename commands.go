@@ -0,0 +1,402 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/mapgen/render"
+	"github.com/alttpo/mapgen/underworld"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type command struct {
+	args     string
+	summary  string
+	flags    func(fs *flag.FlagSet)
+	run      func(fs *flag.FlagSet) error
+	parallel bool // works on -j rooms at once
+}
+
+var commands = map[string]*command{
+	"atlas": {
+		summary: "discover all rooms from every entrance and render the eg1/eg2 atlas images",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&drawEG1, "eg1", true, "create eg1.png")
+			fs.BoolVar(&drawEG2, "eg2", true, "create eg2.png")
+			fs.BoolVar(&cfg.DrawOverlays, "overlay", false, "draw reachable overlays on eg1/eg2")
+			fs.BoolVar(&cfg.DrawNumbers, "numbers", true, "draw room numbers")
+			addSelectionFlags(fs, true)
+			fs.StringVar(&doorPairsPath, "doors", "", "door randomizer connections file overriding vanilla neighbouring supertiles")
+			addWorkerFlags(fs)
+		},
+		run:      runAtlas,
+		parallel: true,
+	},
+	"entrances": {
+		summary: "discover all rooms from every entrance and render per-room artifacts",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&printEntrances, "map", true, "dump entrance-supertile map to stdout")
+			addRoomFlags(fs, false)
+			addSelectionFlags(fs, true)
+			fs.StringVar(&doorPairsPath, "doors", "", "door randomizer connections file overriding vanilla neighbouring supertiles")
+			addWorkerFlags(fs)
+		},
+		run:      runEntrances,
+		parallel: true,
+	},
+	"import": {
+		args:    "[<supertile>]",
+		summary: "analyze and render a supertile as it stands in another emulator's save state or memory dumps",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&importStatePath, "state", "", "save state to import: snes9x freeze file or one written by room -savestate")
+			fs.StringVar(&importWRAMPath, "wramdump", "", "raw WRAM dump ($20000 bytes) to import")
+			fs.StringVar(&importVRAMPath, "vramdump", "", "raw VRAM dump ($10000 bytes) to import")
+			fs.StringVar(&importCGRAMPath, "cgramdump", "", "raw CGRAM dump ($200 bytes) to import")
+			fs.StringVar(&importSRAMPath, "sramdump", "", "raw SRAM dump to import")
+			addRoomFlags(fs, true)
+		},
+		run: runImport,
+	},
+	"room": {
+		args:    "<supertile>",
+		summary: "load and render a single supertile",
+		flags: func(fs *flag.FlagSet) {
+			fs.Var((*hexUint8)(&roomEntranceID), "entrance", "entrance ID whose loaded state the room is loaded from")
+			fs.StringVar(&roomStatePath, "loadstate", "", "save state file with the room loaded to render as it stands instead of loading -entrance")
+			fs.BoolVar(&saveRoomState, "savestate", false, "write the room's emulator state after loading it to a .state file")
+			fs.StringVar(&walkScript, "walk", "", "joypad script to walk Link with from where the entrance leaves him, checking he only stands on tiles found reachable; e.g. right:30,up+b:10")
+			addRoomFlags(fs, true)
+		},
+		run: runRoom,
+	},
+	"scan": {
+		summary: "scan all supertiles for a tile type and dump tile type maps that contain it",
+		flags: func(fs *flag.FlagSet) {
+			fs.Var((*hexUint8)(&scanTileType), "type", "tile type to scan for")
+			addSelectionFlags(fs, false)
+		},
+		run: runScan,
+	},
+}
+
+var (
+	printEntrances bool
+	roomEntranceID uint8
+	walkScript     string
+	roomStatePath  string
+	saveRoomState  bool
+
+	importStatePath string
+	importWRAMPath  string
+	importVRAMPath  string
+	importCGRAMPath string
+	importSRAMPath  string
+	scanTileType    uint8 = 0x0A
+)
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: mapgen <command> [options]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun 'mapgen <command> -h' for command options\n")
+}
+
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&romPath, "rom", "alttp-jp.sfc", "path to ALTTP ROM image (.sfc or copier-headered .smc)")
+	fs.Var(&romVersion, "version", "ROM `revision` (jp1.0, jp1.1, jp1.2, us or eu) of randomizer and pre-patched images the header does not identify")
+	fs.Var(&patchPaths, "patch", "IPS or BPS patch to apply to the ROM before analysis; may be repeated")
+	fs.BoolVar(&harnessConfig.PatchSongBankLoading, "patchsongs", false, "patch out the game's song bank loading instead of answering the APU upload handshake")
+	fs.StringVar(&outputDir, "out", "data", "output directory for all generated files")
+	fs.StringVar(&outputNaming, "name", "{{.Name}}.{{.Ext}}", "output file naming template relative to -out; fields: .ROM .Version .Name .Ext")
+	fs.BoolVar(&cfg.UseGammaRamp, "gamma", false, "use bsnes gamma ramp")
+	fs.BoolVar(&cfg.PaletteFromCGRAM, "cgram", false, "draw rooms with the palette uploaded to CGRAM instead of the WRAM palette buffer")
+	fs.BoolVar(&cfg.DrawBG1p0, "bg1p0", true, "draw BG1 priority 0 tiles")
+	fs.BoolVar(&cfg.DrawBG1p1, "bg1p1", true, "draw BG1 priority 1 tiles")
+	fs.BoolVar(&cfg.DrawBG2p0, "bg2p0", true, "draw BG2 priority 0 tiles")
+	fs.BoolVar(&cfg.DrawBG2p1, "bg2p1", true, "draw BG2 priority 1 tiles")
+	fs.StringVar(&symbolsPath, "symbols", "", "symbol file (WLA/asar, bass or no$sns) of the ROM to relocate routines by and name addresses with")
+	fs.Uint64Var(&cycleBudget, "cycles", emulator.DefaultCycleBudget, "CPU cycles each emulated routine may take before it is reported as hung")
+	fs.IntVar(&historyLen, "history", emulator.DefaultHistoryLen, "instructions to show leading up to an emulation failure; -1 = none")
+	addTraceFlags(fs)
+	addWatchFlags(fs)
+}
+
+func addWorkerFlags(fs *flag.FlagSet) {
+	fs.IntVar(&cfg.Workers, "j", cfg.Workers, "number of entrances/rooms to process in parallel")
+}
+
+func addRoomFlags(fs *flag.FlagSet, roomPNGs bool) {
+	fs.BoolVar(&cfg.DrawRoomPNGs, "roompngs", roomPNGs, "create individual room PNGs")
+	fs.BoolVar(&cfg.DrawBGLayerPNGs, "bgpngs", false, "create individual room BG layer PNGs")
+	fs.BoolVar(&cfg.SupertileGIFs, "gifs", false, "render room GIFs")
+	fs.BoolVar(&cfg.OptimizeGIFs, "optimize", true, "optimize GIFs for size with delta frames")
+	fs.BoolVar(&cfg.AnimateRoomDrawing, "animate", false, "render animated room drawing GIFs")
+	fs.IntVar(&cfg.AnimateRoomDrawingDelay, "animdelay", 15, "room drawing GIF frame delay")
+}
+
+func runAtlas(fs *flag.FlagSet) (err error) {
+	if err = initSystem(); err != nil {
+		return
+	}
+
+	var entranceGroups []underworld.Entrance
+	if entranceGroups, err = cfg.DiscoverEntrances(); err != nil {
+		// the failures may be why nothing selected was reached:
+		_ = cfg.ReportFailures()
+		return
+	}
+
+	// condense all maps into big atlas images:
+	var eg1Err, eg2Err error
+	wg := sync.WaitGroup{}
+	if drawEG1 {
+		wg.Add(1)
+		go func() {
+			eg1Err = cfg.RenderAtlas("eg1", entranceGroups, 0x00, 0x10)
+			wg.Done()
+		}()
+	}
+	if drawEG2 {
+		wg.Add(1)
+		go func() {
+			eg2Err = cfg.RenderAtlas("eg2", entranceGroups, 0x10, 0x3)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if err = cfg.ReportFailures(); err != nil {
+		return
+	}
+	if eg1Err != nil {
+		return eg1Err
+	}
+	return eg2Err
+}
+
+func runEntrances(fs *flag.FlagSet) (err error) {
+	if err = initSystem(); err != nil {
+		return
+	}
+
+	var entranceGroups []underworld.Entrance
+	if entranceGroups, err = cfg.DiscoverEntrances(); err != nil {
+		// the failures may be why nothing selected was reached:
+		_ = cfg.ReportFailures()
+		return
+	}
+
+	if printEntrances {
+		printEntranceMap(entranceGroups)
+	}
+
+	return cfg.ReportFailures()
+}
+
+func runRoom(fs *flag.FlagSet) (err error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var st uint64
+	if st, err = parseHex(fs.Arg(0), 16); err != nil {
+		return
+	}
+	if st >= 0x128 {
+		return fmt.Errorf("room: supertile $%03x out of range", st)
+	}
+
+	var script []emulator.JoypadInput
+	if walkScript != "" {
+		if script, err = emulator.ParseJoypadScript(walkScript); err != nil {
+			return
+		}
+	}
+
+	if err = initSystem(); err != nil {
+		return
+	}
+
+	var room *underworld.RoomState
+	if roomStatePath != "" {
+		room, err = cfg.LoadRoomFromState(roomStatePath, underworld.Supertile(st))
+	} else {
+		room, err = cfg.LoadRoom(roomEntranceID, underworld.Supertile(st))
+	}
+	if err != nil {
+		return
+	}
+
+	if saveRoomState {
+		path := outputPath(fmt.Sprintf("%03x", uint16(room.Supertile)), "state")
+		if err = render.CreateParentDir(path); err != nil {
+			return
+		}
+		if err = room.SaveState(path); err != nil {
+			return
+		}
+	}
+
+	if script != nil {
+		if err = walkRoom(room, script); err != nil {
+			return
+		}
+	}
+
+	if cfg.SupertileGIFs {
+		if err = render.RenderGIF(&room.GIF, outputPath(fmt.Sprintf("%03x", uint16(room.Supertile)), "gif")); err != nil {
+			return
+		}
+	}
+	if cfg.AnimateRoomDrawing {
+		if err = render.RenderGIF(&room.Animated, outputPath(fmt.Sprintf("%03x.room", uint16(room.Supertile)), "gif")); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// walkRoom flood fills the room from Link's position, then walks Link with the joypad script and reports every
+// tile he stood on that was not found reachable:
+func walkRoom(room *underworld.RoomState, script []emulator.JoypadInput) (err error) {
+	room.Lock()
+	defer room.Unlock()
+
+	ep := room.LinkEntryPoint()
+	if _, err = cfg.Reachability(room, ep); err != nil {
+		return
+	}
+
+	var steps []underworld.LinkStep
+	if steps, err = room.WalkLink(script); err != nil {
+		return
+	}
+	fmt.Printf("walked Link for %d frame(s) from %s\n", len(steps), ep)
+
+	missed := room.UnreachedSteps(steps)
+	for _, s := range missed {
+		fmt.Printf("  frame %d: Link stood on %s in %s which was not found reachable\n", s.Frame, s.Point, s.Supertile)
+	}
+	if len(missed) != 0 {
+		err = fmt.Errorf("room: Link stood on %d tile(s) not found reachable", len(missed))
+	}
+	return
+}
+
+func runImport(fs *flag.FlagSet) (err error) {
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if importStatePath == "" && importWRAMPath == "" {
+		return fmt.Errorf("import: -state or -wramdump is required")
+	}
+
+	if err = initSystem(); err != nil {
+		return
+	}
+
+	// import over a copy of the initialized system so the harness routines stay in place:
+	e := &emulator.System{}
+	if err = e.InitEmulatorFrom(cfg.Harness.System); err != nil {
+		return
+	}
+	if importStatePath != "" {
+		if err = e.ImportStateFile(importStatePath); err != nil {
+			return
+		}
+	}
+	if err = e.ImportRAMDumps(importWRAMPath, importVRAMPath, importCGRAMPath, importSRAMPath); err != nil {
+		return
+	}
+
+	// default to the supertile the game has loaded:
+	st := uint64(e.ReadWRAM16(0xA0))
+	if fs.NArg() == 1 {
+		if st, err = parseHex(fs.Arg(0), 16); err != nil {
+			return
+		}
+	}
+	if st >= 0x128 {
+		return fmt.Errorf("import: supertile $%03x out of range", st)
+	}
+
+	var room *underworld.RoomState
+	if room, err = cfg.LoadImportedRoom(e, underworld.Supertile(st)); err != nil {
+		return
+	}
+
+	room.Lock()
+	defer room.Unlock()
+
+	ep := room.LinkEntryPoint()
+	var exits []underworld.EntryPoint
+	if exits, err = cfg.Reachability(room, ep); err != nil {
+		return
+	}
+	fmt.Printf("%s: reachable from %s\n", room.Supertile, ep)
+	for _, x := range exits {
+		fmt.Printf("  exit to %s\n", x)
+	}
+
+	if cfg.SupertileGIFs {
+		if err = render.RenderGIF(&room.GIF, outputPath(fmt.Sprintf("%03x", uint16(room.Supertile)), "gif")); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func runScan(fs *flag.FlagSet) (err error) {
+	if err = initSystem(); err != nil {
+		return
+	}
+
+	return cfg.ScanForTileTypes(scanTileType)
+}
+
+// parseHex parses a hexadecimal number with an optional "$" or "0x" prefix:
+func parseHex(s string, bitSize int) (uint64, error) {
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return strconv.ParseUint(s, 16, bitSize)
+}
+
+// hexUint8 is a flag.Value for a hexadecimal byte:
+type hexUint8 uint8
+
+func (v *hexUint8) String() string { return fmt.Sprintf("$%02x", uint8(*v)) }
+
+func (v *hexUint8) Set(s string) error {
+	n, err := parseHex(s, 8)
+	if err != nil {
+		return err
+	}
+	*v = hexUint8(n)
+	return nil
+}
+
+// patchList is a flag.Value collecting -patch paths in the order they are given:
+type patchList []string
+
+func (l *patchList) String() string { return strings.Join(*l, ",") }
+
+func (l *patchList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var patchPaths patchList
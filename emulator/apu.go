@@ -0,0 +1,74 @@
+package emulator
+
+type apuState byte
+
+const (
+	apuIPLReady    apuState = iota // the IPL ROM has signalled $BBAA and waits for $CC
+	apuIPLTransfer                 // the IPL ROM acknowledges each byte uploaded to it
+	apuRunning                     // an uploaded program is running
+)
+
+// APU stands in for the sound CPU behind $2140-$2143. It answers the IPL boot ROM's upload handshake so that
+// game code which uploads sound programs and song data runs to completion; nothing uploaded is executed:
+type APU struct {
+	state apuState
+	in    [4]byte // last values written by the CPU
+	out   [4]byte // values the CPU reads back
+
+	counter    byte // index of the next byte of the block being uploaded
+	blockStart bool // a block start or jump was written to port 0; port 1 tells which on the next read
+}
+
+func (a *APU) read(port uint32) byte {
+	switch a.state {
+	case apuIPLReady:
+		return [4]byte{0xAA, 0xBB, 0x00, 0x00}[port]
+	case apuIPLTransfer:
+		if a.blockStart {
+			// a zero command in port 1 ends the upload and jumps to the uploaded program; a 16-bit write
+			// to ports 0 and 1 arrives low byte first so this is only checked once the CPU reads:
+			a.blockStart = false
+			if a.in[1] == 0 {
+				a.state = apuRunning
+			}
+		}
+	}
+	return a.out[port]
+}
+
+func (a *APU) write(port uint32, value byte) {
+	a.in[port] = value
+
+	switch a.state {
+	case apuIPLReady:
+		// the upload starts with $CC written to port 0:
+		if port == 0 && value == 0xCC {
+			a.state = apuIPLTransfer
+			a.out = [4]byte{0xCC}
+			a.counter = 0
+			a.blockStart = true
+		}
+	case apuIPLTransfer:
+		if port != 0 {
+			return
+		}
+		// port 0 holds the index of the data byte in port 1; any other value starts a new block or jumps:
+		if value == a.counter {
+			a.counter++
+			a.blockStart = false
+		} else {
+			a.counter = 0
+			a.blockStart = true
+		}
+		a.out[0] = value
+	case apuRunning:
+		// N-SPC sound drivers go back to the IPL ROM when $FF is written to port 0; otherwise echo what
+		// the CPU writes as an acknowledgement:
+		if port == 0 && value == 0xFF {
+			a.state = apuIPLReady
+			a.out = [4]byte{}
+			return
+		}
+		a.out[port] = value
+	}
+}
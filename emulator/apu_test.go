@@ -0,0 +1,52 @@
+package emulator
+
+import "testing"
+
+func TestAPUIPLHandshake(t *testing.T) {
+	a := &APU{}
+
+	// step is a write to a port, if any, followed by the port 0 and 1 values the CPU reads back:
+	type step struct {
+		port  uint32
+		value byte
+		write bool
+		want  [2]byte
+		state apuState
+	}
+	steps := []step{
+		{want: [2]byte{0xAA, 0xBB}, state: apuIPLReady},
+		// block address $0200, then start with $CC:
+		{port: 2, value: 0x00, write: true, want: [2]byte{0xAA, 0xBB}, state: apuIPLReady},
+		{port: 3, value: 0x02, write: true, want: [2]byte{0xAA, 0xBB}, state: apuIPLReady},
+		{port: 1, value: 0x01, write: true, want: [2]byte{0xAA, 0xBB}, state: apuIPLReady},
+		{port: 0, value: 0xCC, write: true, want: [2]byte{0xCC, 0x00}, state: apuIPLTransfer},
+		// data bytes are acknowledged by echoing their index:
+		{port: 1, value: 0x8F, write: true, want: [2]byte{0xCC, 0x00}, state: apuIPLTransfer},
+		{port: 0, value: 0x00, write: true, want: [2]byte{0x00, 0x00}, state: apuIPLTransfer},
+		{port: 1, value: 0x6C, write: true, want: [2]byte{0x00, 0x00}, state: apuIPLTransfer},
+		{port: 0, value: 0x01, write: true, want: [2]byte{0x01, 0x00}, state: apuIPLTransfer},
+		// a non-zero port 1 with a skipped index starts another block:
+		{port: 1, value: 0x01, write: true, want: [2]byte{0x01, 0x00}, state: apuIPLTransfer},
+		{port: 0, value: 0x03, write: true, want: [2]byte{0x03, 0x00}, state: apuIPLTransfer},
+		{port: 1, value: 0x55, write: true, want: [2]byte{0x03, 0x00}, state: apuIPLTransfer},
+		{port: 0, value: 0x00, write: true, want: [2]byte{0x00, 0x00}, state: apuIPLTransfer},
+		// a zero port 1 with a skipped index jumps to the uploaded program:
+		{port: 1, value: 0x00, write: true, want: [2]byte{0x00, 0x00}, state: apuIPLTransfer},
+		{port: 0, value: 0x02, write: true, want: [2]byte{0x02, 0x00}, state: apuRunning},
+		// the running driver echoes writes until $FF sends it back to the IPL ROM:
+		{port: 1, value: 0x42, write: true, want: [2]byte{0x02, 0x42}, state: apuRunning},
+		{port: 0, value: 0xFF, write: true, want: [2]byte{0xAA, 0xBB}, state: apuIPLReady},
+	}
+	for i, st := range steps {
+		if st.write {
+			a.write(st.port, st.value)
+		}
+		got := [2]byte{a.read(0), a.read(1)}
+		if got != st.want {
+			t.Errorf("step %d: read % 02x; want % 02x", i, got, st.want)
+		}
+		if a.state != st.state {
+			t.Errorf("step %d: state %d; want %d", i, a.state, st.state)
+		}
+	}
+}
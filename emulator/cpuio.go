@@ -0,0 +1,212 @@
+package emulator
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// there is no PPU timing model so the H/V counters are derived from the CPU cycle count, assuming an
+// average of 6 master clocks per CPU cycle:
+const (
+	masterClocksPerCycle = 6
+	masterClocksPerLine  = 1364
+	dotsPerLine          = 340
+	linesPerFrame        = 262
+	vblankStartLine      = 225
+	hblankStartDot       = 274
+)
+
+// CPUIO holds the state of the CPU-side math, NMI and counter latch registers:
+type CPUIO struct {
+	wrmpya byte   // $4202
+	wrdiv  uint16 // $4204/$4205
+	rddiv  uint16 // $4214/$4215 quotient or multiplier
+	rdmpy  uint16 // $4216/$4217 product or remainder
+
+	nmiReadFrame uint64 // 1 + the frame whose NMI flag was last read from $4210
+
+	hLatch, vLatch uint16 // counters latched by reading $2137
+	hLatchHigh     bool   // $213C flip-flop
+	vLatchHigh     bool   // $213D flip-flop
+	latched        bool   // $213F bit 6
+}
+
+// unimplementedReadCounts counts reads of registers in $2000-$4FFF that HWIO does not emulate; it is shared
+// by a System and all the systems initialized from it:
+type unimplementedReadCounts [0x3000]uint32
+
+// counters returns the frame, scanline and dot the PPU would be at:
+func (h *HWIO) counters() (frame uint64, v, hdot uint16) {
+	clocks := h.s.CPU.AllCycles * masterClocksPerCycle
+	line := clocks / masterClocksPerLine
+	frame = line / linesPerFrame
+	v = uint16(line % linesPerFrame)
+	hdot = uint16(clocks % masterClocksPerLine * dotsPerLine / masterClocksPerLine)
+	return
+}
+
+func (h *HWIO) readCPUIO(offs uint32) (value byte, ok bool) {
+	ok = true
+	switch offs {
+	case 0x2137:
+		// SLHV: latch the H/V counters; reads as open bus
+		_, h.CPUIO.vLatch, h.CPUIO.hLatch = h.counters()
+		h.CPUIO.latched = true
+	case 0x213C:
+		// OPHCT: low byte then bit 8 of the latched H counter
+		if h.CPUIO.hLatchHigh {
+			value = byte(h.CPUIO.hLatch>>8) & 1
+		} else {
+			value = byte(h.CPUIO.hLatch)
+		}
+		h.CPUIO.hLatchHigh = !h.CPUIO.hLatchHigh
+	case 0x213D:
+		// OPVCT:
+		if h.CPUIO.vLatchHigh {
+			value = byte(h.CPUIO.vLatch>>8) & 1
+		} else {
+			value = byte(h.CPUIO.vLatch)
+		}
+		h.CPUIO.vLatchHigh = !h.CPUIO.vLatchHigh
+	case 0x213E:
+		// STAT77: PPU1 version 1
+		value = 0x01
+	case 0x213F:
+		// STAT78: counter latch flag, NTSC, PPU2 version 3; reading resets the OPHCT/OPVCT flip-flops
+		value = 0x03
+		if h.CPUIO.latched {
+			value |= 0x40
+		}
+		h.CPUIO.latched = false
+		h.CPUIO.hLatchHigh = false
+		h.CPUIO.vLatchHigh = false
+	case 0x4016:
+		// JOYSER0: controller 1 read a bit at a time
+		value = h.Joypad.readSerial()
+	case 0x4017:
+		// JOYSER1: no controller 2; bits 2-4 always read as 1
+		value = 0x1C
+	case 0x4218:
+		// JOY1L
+		value = byte(h.Joypad.Buttons)
+	case 0x4219:
+		// JOY1H
+		value = byte(h.Joypad.Buttons >> 8)
+	case 0x421A, 0x421B, 0x421C, 0x421D, 0x421E, 0x421F:
+		// JOY2-JOY4: not connected
+		value = 0
+	case 0x4210:
+		// RDNMI: the NMI flag is set once per frame at the start of vblank and cleared by reading; CPU version 2
+		value = 0x02
+		if frame, v, _ := h.counters(); v >= vblankStartLine && h.CPUIO.nmiReadFrame != frame+1 {
+			h.CPUIO.nmiReadFrame = frame + 1
+			value |= 0x80
+		}
+	case 0x4211:
+		// TIMEUP: IRQs are never raised
+		value = 0
+	case 0x4212:
+		// HVBJOY: vblank and hblank; auto-joypad read is never busy
+		_, v, hdot := h.counters()
+		if v >= vblankStartLine {
+			value |= 0x80
+		}
+		if hdot >= hblankStartDot || hdot < 1 {
+			value |= 0x40
+		}
+	case 0x4214:
+		// RDDIVL
+		value = byte(h.CPUIO.rddiv)
+	case 0x4215:
+		// RDDIVH
+		value = byte(h.CPUIO.rddiv >> 8)
+	case 0x4216:
+		// RDMPYL
+		value = byte(h.CPUIO.rdmpy)
+	case 0x4217:
+		// RDMPYH
+		value = byte(h.CPUIO.rdmpy >> 8)
+	default:
+		ok = false
+	}
+	return
+}
+
+func (h *HWIO) writeCPUIO(offs uint32, value byte) (ok bool) {
+	ok = true
+	switch offs {
+	case 0x4016:
+		// JOYWR: latch the controllers while bit 0 is set
+		h.Joypad.writeStrobe(value)
+	case 0x4202:
+		// WRMPYA
+		h.CPUIO.wrmpya = value
+	case 0x4203:
+		// WRMPYB: starts an unsigned 8x8 multiply; results are available immediately
+		h.CPUIO.rdmpy = uint16(h.CPUIO.wrmpya) * uint16(value)
+		h.CPUIO.rddiv = uint16(value)
+	case 0x4204:
+		// WRDIVL
+		h.CPUIO.wrdiv = h.CPUIO.wrdiv&0xFF00 | uint16(value)
+	case 0x4205:
+		// WRDIVH
+		h.CPUIO.wrdiv = uint16(value)<<8 | h.CPUIO.wrdiv&0x00FF
+	case 0x4206:
+		// WRDIVB: starts an unsigned 16/8 divide; dividing by zero gives a quotient of $FFFF and the
+		// dividend as the remainder
+		if value == 0 {
+			h.CPUIO.rddiv = 0xFFFF
+			h.CPUIO.rdmpy = h.CPUIO.wrdiv
+		} else {
+			h.CPUIO.rddiv = h.CPUIO.wrdiv / uint16(value)
+			h.CPUIO.rdmpy = h.CPUIO.wrdiv % uint16(value)
+		}
+	default:
+		ok = false
+	}
+	return
+}
+
+// unimplementedRead counts a read of a register HWIO does not emulate and logs the first one of each:
+func (h *HWIO) unimplementedRead(offs uint32) {
+	if h.unimplemented == nil || offs < 0x2000 || offs >= 0x5000 {
+		return
+	}
+	if atomic.AddUint32(&h.unimplemented[offs-0x2000], 1) == 1 && h.s.Logger != nil {
+		fmt.Fprintf(h.s.Logger, "hwio[$%04x] -> unimplemented register read\n", offs)
+	}
+}
+
+// UnimplementedReads returns the number of reads of each register HWIO does not emulate, keyed by address:
+func (h *HWIO) UnimplementedReads() (counts map[uint16]uint32) {
+	counts = make(map[uint16]uint32)
+	if h.unimplemented == nil {
+		return
+	}
+	for i := range h.unimplemented {
+		if n := atomic.LoadUint32(&h.unimplemented[i]); n != 0 {
+			counts[uint16(0x2000+i)] = n
+		}
+	}
+	return
+}
+
+// ReportUnimplementedReads prints the registers read by game code that are not emulated, if any:
+func (h *HWIO) ReportUnimplementedReads() {
+	counts := h.UnimplementedReads()
+	if len(counts) == 0 {
+		return
+	}
+
+	addrs := make([]uint16, 0, len(counts))
+	for a := range counts {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	fmt.Printf("%d unimplemented register(s) read:\n", len(addrs))
+	for _, a := range addrs {
+		fmt.Printf("  $%04x: %d read(s)\n", a, counts[a])
+	}
+}
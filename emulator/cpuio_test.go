@@ -0,0 +1,35 @@
+package emulator
+
+import "testing"
+
+func TestMathRegisters(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes [][2]uint32 // register, value
+		rddiv  uint16
+		rdmpy  uint16
+	}{
+		{"multiply", [][2]uint32{{0x4202, 0xFF}, {0x4203, 0xFF}}, 0x00FF, 0xFE01},
+		{"multiply by zero", [][2]uint32{{0x4202, 0x12}, {0x4203, 0x00}}, 0x0000, 0x0000},
+		{"divide", [][2]uint32{{0x4204, 0x39}, {0x4205, 0x30}, {0x4206, 0x10}}, 0x0303, 0x0009},
+		{"divide by zero", [][2]uint32{{0x4204, 0x34}, {0x4205, 0x12}, {0x4206, 0x00}}, 0xFFFF, 0x1234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSystem(t)
+			h := &s.HWIO
+			for _, w := range tt.writes {
+				h.Write(w[0], byte(w[1]))
+			}
+
+			rddiv := uint16(h.Read(0x4214)) | uint16(h.Read(0x4215))<<8
+			rdmpy := uint16(h.Read(0x4216)) | uint16(h.Read(0x4217))<<8
+			if rddiv != tt.rddiv {
+				t.Errorf("RDDIV = $%04x; want $%04x", rddiv, tt.rddiv)
+			}
+			if rdmpy != tt.rdmpy {
+				t.Errorf("RDMPY = $%04x; want $%04x", rdmpy, tt.rdmpy)
+			}
+		})
+	}
+}
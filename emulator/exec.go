@@ -0,0 +1,217 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alttpo/snes/emulator/cpualt"
+	"io"
+	"strings"
+)
+
+// defaults for System.CycleBudget and System.HistoryLen:
+const (
+	DefaultCycleBudget = 0x1000_0000
+	DefaultHistoryLen  = 64
+)
+
+// ExecStop tells why RunUntil stopped short of its target PC:
+type ExecStop int
+
+const (
+	ExecTimeout ExecStop = iota // the cycle budget ran out
+	ExecSTP                     // an STP instruction stopped the CPU
+	ExecWDM                     // a WDM instruction was about to execute with no OnWDM handler
+	ExecBRK                     // a BRK instruction was about to execute
+)
+
+func (r ExecStop) String() string {
+	switch r {
+	case ExecTimeout:
+		return "timeout"
+	case ExecSTP:
+		return "STP"
+	case ExecWDM:
+		return "WDM"
+	case ExecBRK:
+		return "BRK"
+	}
+	return fmt.Sprintf("ExecStop(%d)", int(r))
+}
+
+// CPURegs is a snapshot of the 65816 registers:
+type CPURegs struct {
+	PC      uint32 // program bank and counter
+	A, X, Y uint16
+	S, D    uint16
+	DB      byte
+	P       byte
+	E       bool
+}
+
+func (r *CPURegs) capture(c *cpualt.CPU) {
+	*r = CPURegs{
+		PC: uint32(c.RK)<<16 | uint32(c.PC),
+		A:  c.RA, X: c.RX, Y: c.RY,
+		S: c.SP, D: c.RD,
+		DB: c.RDBR,
+		P:  c.Flags(),
+		E:  c.E != 0,
+	}
+	// the 8-bit halves are kept separately while M or X are set:
+	if c.M != 0 {
+		r.A = uint16(c.RAh)<<8 | uint16(c.RAl)
+	}
+	if c.X != 0 {
+		r.X, r.Y = uint16(c.RXl), uint16(c.RYl)
+	}
+}
+
+// apply sets the CPU's registers; the flags are set directly since SetFlags would also resize the registers:
+func (r *CPURegs) apply(c *cpualt.CPU) {
+	c.RK, c.PC = byte(r.PC>>16), uint16(r.PC)
+	c.RA, c.RAl, c.RAh = r.A, byte(r.A), byte(r.A>>8)
+	c.RX, c.RXl = r.X, byte(r.X)
+	c.RY, c.RYl = r.Y, byte(r.Y)
+	c.SP, c.RD, c.RDBR = r.S, r.D, r.DB
+	for i, f := range []*byte{&c.C, &c.Z, &c.I, &c.D, &c.X, &c.M, &c.V, &c.N} {
+		*f = r.P >> i & 1
+	}
+	c.E = 0
+	if r.E {
+		c.E = 1
+	}
+}
+
+func (r CPURegs) String() string {
+	e := 0
+	if r.E {
+		e = 1
+	}
+	return fmt.Sprintf("PC=$%06x A=$%04x X=$%04x Y=$%04x S=$%04x D=$%04x DB=$%02x P=$%02x E=%d", r.PC, r.A, r.X, r.Y, r.S, r.D, r.DB, r.P, e)
+}
+
+// CallFrame is a JSR or JSL that has not returned yet:
+type CallFrame struct {
+	From, To uint32 // address of the call instruction and of the routine called
+	sp       uint16 // stack pointer before the call
+}
+
+// trackCalls pushes a frame for a call just executed and drops the frames whose return addresses the stack no
+// longer holds. Dropping frames by stack pointer rather than counting returns keeps the stack right when game
+// code pulls return addresses off to jump through tables:
+func (s *System) trackCalls(opcode byte, from uint32, sp uint16) {
+	for n := len(s.calls); n > 0 && s.CPU.SP >= s.calls[n-1].sp; n-- {
+		s.calls = s.calls[:n-1]
+	}
+
+	switch opcode {
+	case 0x20, 0x22, 0xFC: // JSR abs, JSL, JSR (abs,X)
+		s.calls = append(s.calls, CallFrame{From: from, To: s.GetPC(), sp: sp})
+	}
+}
+
+// ExecError reports where and why execution stopped short of its target PC, with the instructions and calls
+// that led there:
+type ExecError struct {
+	Stop     ExecStop
+	TargetPC uint32
+	Cycles   uint64
+	Regs     CPURegs     // registers at the stop
+	Calls    []CallFrame // outermost first
+	History  []string    // disassembly of the last instructions executed, oldest first
+
+	symbols *Symbols
+}
+
+func (e *ExecError) Error() string {
+	what := "stopped by " + e.Stop.String()
+	if e.Stop == ExecTimeout {
+		what = "ran too long"
+	}
+	return fmt.Sprintf(
+		"CPU %s at %s and did not reach PC=%s; took %d cycles",
+		what,
+		addrName(e.symbols, e.Regs.PC),
+		addrName(e.symbols, e.TargetPC),
+		e.Cycles,
+	)
+}
+
+// Dump writes the registers, call stack and instruction history:
+func (e *ExecError) Dump(w io.Writer) {
+	fmt.Fprintf(w, "  registers: %s\n", e.Regs)
+	fmt.Fprintf(w, "  call stack, innermost first:\n")
+	for i := len(e.Calls) - 1; i >= 0; i-- {
+		c := e.Calls[i]
+		fmt.Fprintf(w, "    %s called from %s\n", addrName(e.symbols, c.To), addrName(e.symbols, c.From))
+	}
+	fmt.Fprintf(w, "  last %d instruction(s):\n", len(e.History))
+	for _, line := range e.History {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+func addrName(syms *Symbols, addr uint32) string {
+	if name := syms.Format(addr); name != "" {
+		return fmt.Sprintf("%#06x (%s)", addr, name)
+	}
+	return fmt.Sprintf("%#06x", addr)
+}
+
+// recordHistory keeps the registers before each instruction in a ring buffer of HistoryLen entries:
+func (s *System) recordHistory() {
+	n := s.HistoryLen
+	if n == 0 {
+		n = DefaultHistoryLen
+	}
+	if n < 0 {
+		return
+	}
+	if len(s.history) != n {
+		s.history = make([]CPURegs, n)
+		s.historyNext, s.historyLen = 0, 0
+	}
+
+	s.history[s.historyNext].capture(&s.CPU)
+	s.historyNext = (s.historyNext + 1) % n
+	if s.historyLen < n {
+		s.historyLen++
+	}
+}
+
+// execError builds the error for a stop, disassembling the history against memory as it is now:
+func (s *System) execError(stop ExecStop, targetPC uint32, cycles uint64) *ExecError {
+	e := &ExecError{
+		Stop:     stop,
+		TargetPC: targetPC,
+		Cycles:   cycles,
+		Calls:    append([]CallFrame(nil), s.calls...),
+		symbols:  s.Symbols,
+	}
+	e.Regs.capture(&s.CPU)
+
+	// disassemble with a copy of the CPU set to each instruction's registers:
+	s.peeking = true
+	defer func() { s.peeking = false }()
+	c := &cpualt.CPU{}
+	c.InitFrom(&s.CPU)
+	b := &bytes.Buffer{}
+	for i := 0; i < s.historyLen; i++ {
+		r := s.history[(s.historyNext-s.historyLen+i+len(s.history))%len(s.history)]
+		r.apply(c)
+
+		b.Reset()
+		c.DisassembleTo(c.PC, b)
+		line := b.String()
+		// drop the disassembler's leading cycle count:
+		if j := strings.IndexByte(line, '\t'); j >= 0 {
+			line = line[j+1:]
+		}
+		if name := s.Symbols.Format(r.PC); name != "" {
+			line += " ; " + name
+		}
+		e.History = append(e.History, line)
+	}
+
+	return e
+}
@@ -0,0 +1,105 @@
+package emulator
+
+// visible scanlines per frame:
+const ScanlinesPerFrame = 224
+
+// HDMA table state kept in the channel registers:
+func (c *DMARegs) tableAddr() uint16    { return uint16(c[9])<<8 | uint16(c[8]) }
+func (c *DMARegs) indirectAddr() uint16 { return uint16(c[6])<<8 | uint16(c[5]) }
+func (c *DMARegs) indirectBank() byte   { return c[7] }
+func (c *DMARegs) lineCounter() byte    { return c[10] }
+
+func (c *DMARegs) setTableAddr(a uint16)    { c[8], c[9] = byte(a), byte(a>>8) }
+func (c *DMARegs) setIndirectAddr(a uint16) { c[5], c[6] = byte(a), byte(a>>8) }
+
+// hdmaReload reads the next table entry's line count and, in indirect mode, its data address:
+func (c *DMAChannel) hdmaReload(regs *DMARegs, h *HWIO) {
+	bank := uint32(regs.srcB()) << 16
+	a := regs.tableAddr()
+
+	regs[10] = h.s.Bus.EaRead(bank | uint32(a))
+	a++
+	if regs.lineCounter() == 0 {
+		c.hdmaCompleted = true
+	} else if regs.ctrl()&0x40 != 0 {
+		lo := h.s.Bus.EaRead(bank | uint32(a))
+		hi := h.s.Bus.EaRead(bank | uint32(a+1))
+		regs.setIndirectAddr(uint16(hi)<<8 | uint16(lo))
+		a += 2
+	}
+	regs.setTableAddr(a)
+	c.hdmaDoTransfer = true
+}
+
+// hdmaInit starts the channel's table over at the top of the frame:
+func (c *DMAChannel) hdmaInit(regs *DMARegs, h *HWIO) {
+	regs.setTableAddr(uint16(regs.srcH())<<8 | uint16(regs.srcL()))
+	c.hdmaCompleted = false
+	c.hdmaReload(regs, h)
+}
+
+// hdmaLine does the channel's transfer for one scanline and advances its table. Only the PPU registers rendering
+// uses are written; writes to VRAM, CGRAM, OAM and the other B-bus ports, and PPU to CPU transfers, are dropped:
+func (c *DMAChannel) hdmaLine(regs *DMARegs, h *HWIO) {
+	if c.hdmaCompleted {
+		return
+	}
+
+	if c.hdmaDoTransfer {
+		bDestAddr := uint32(regs.dest()) | 0x2100
+		for _, p := range dmaPatterns[regs.ctrl()&7] {
+			var aSrc uint32
+			if regs.ctrl()&0x40 != 0 {
+				a := regs.indirectAddr()
+				aSrc = uint32(regs.indirectBank())<<16 | uint32(a)
+				regs.setIndirectAddr(a + 1)
+			} else {
+				a := regs.tableAddr()
+				aSrc = uint32(regs.srcB())<<16 | uint32(a)
+				regs.setTableAddr(a + 1)
+			}
+
+			bAddr := 0x2100 | (bDestAddr+p)&0xFF
+			if regs.ctrl()&0x80 == 0 {
+				h.writePPURegs(bAddr, h.s.Bus.EaRead(aSrc))
+			}
+		}
+	}
+
+	// bit 7 of the line count repeats the transfer on every line rather than only the first:
+	regs[10]--
+	c.hdmaDoTransfer = regs.lineCounter()&0x80 != 0
+	if regs.lineCounter()&0x7F == 0 {
+		c.hdmaReload(regs, h)
+	}
+}
+
+// RunHDMAFrame walks the tables of the HDMA channels enabled by HDMAEN over one frame and returns the PPU
+// registers as they stand on each visible scanline, or nil if no channel is enabled. The frame leaves no trace:
+// the channel registers and the frame's starting PPU registers are restored afterwards, since the game rewrites
+// them every NMI anyway, and only writes to the PPU registers are made:
+func (h *HWIO) RunHDMAFrame() (lines []PPURegs) {
+	if h.HDMAEN == 0 {
+		return
+	}
+
+	start, startRegs, startDMA := h.PPURegs, h.DMARegs, h.DMA
+	for c := range h.DMA {
+		if h.HDMAEN&(1<<c) != 0 {
+			h.DMA[c].hdmaInit(&h.DMARegs[c], h)
+		}
+	}
+
+	lines = make([]PPURegs, ScanlinesPerFrame)
+	for y := range lines {
+		for c := range h.DMA {
+			if h.HDMAEN&(1<<c) != 0 {
+				h.DMA[c].hdmaLine(&h.DMARegs[c], h)
+			}
+		}
+		lines[y] = h.PPURegs
+	}
+
+	h.PPURegs, h.DMARegs, h.DMA = start, startRegs, startDMA
+	return
+}
@@ -0,0 +1,419 @@
+package emulator
+
+import "fmt"
+
+type DMARegs [16]byte
+
+func (c *DMARegs) ctrl() byte { return c[0] }
+func (c *DMARegs) dest() byte { return c[1] }
+func (c *DMARegs) srcL() byte { return c[2] }
+func (c *DMARegs) srcH() byte { return c[3] }
+func (c *DMARegs) srcB() byte { return c[4] }
+func (c *DMARegs) sizL() byte { return c[5] }
+func (c *DMARegs) sizH() byte { return c[6] }
+
+type DMAChannel struct {
+	hdmaDoTransfer bool // transfer on the next scanline
+	hdmaCompleted  bool // the table's terminating zero line count was read
+}
+
+// dmaPatterns lists the B-bus register offsets written (or read) for each transfer mode; the pattern
+// repeats until the byte count runs out:
+var dmaPatterns = [8][]uint32{
+	0: {0},
+	1: {0, 1},
+	2: {0, 0},
+	3: {0, 0, 1, 1},
+	4: {0, 1, 2, 3},
+	5: {0, 1, 0, 1},
+	6: {0, 0},
+	7: {0, 0, 1, 1},
+}
+
+func (c *DMAChannel) Transfer(regs *DMARegs, ch int, h *HWIO) {
+	aSrc := uint32(regs.srcB())<<16 | uint32(regs.srcH())<<8 | uint32(regs.srcL())
+	siz := uint16(regs.sizH())<<8 | uint16(regs.sizL())
+
+	bDest := regs.dest()
+	bDestAddr := uint32(bDest) | 0x2100
+
+	incr := regs.ctrl()&0x10 == 0
+	fixed := regs.ctrl()&0x08 != 0
+	mode := regs.ctrl() & 7
+	toCPU := regs.ctrl()&0x80 != 0
+
+	//if h.s.Logger != nil {
+	//	fmt.Fprintf(h.s.Logger, "PC=$%06x\n", h.s.GetPC())
+	//	fmt.Fprintf(h.s.Logger, "DMA[%d] start: $%06x -> $%04x [$%05x]\n", ch, aSrc, bDestAddr, siz)
+	//}
+
+	pattern := dmaPatterns[mode]
+
+	// a size of 0 transfers $10000 bytes:
+copyloop:
+	for {
+		for _, p := range pattern {
+			// B-bus addresses wrap within $21xx:
+			bAddr := 0x2100 | (bDestAddr+p)&0xFF
+			if toCPU {
+				// PPU -> CPU
+				h.s.Bus.EaWrite(aSrc, h.Read(bAddr))
+			} else {
+				// CPU -> PPU
+				h.Write(bAddr, h.s.Bus.EaRead(aSrc))
+			}
+
+			// the A-bus address stays within its bank:
+			if !fixed {
+				if incr {
+					aSrc = ((aSrc&0xFFFF)+1)&0xFFFF + aSrc&0xFF0000
+				} else {
+					aSrc = ((aSrc&0xFFFF)-1)&0xFFFF + aSrc&0xFF0000
+				}
+			}
+			siz--
+			if siz == 0 {
+				break copyloop
+			}
+		}
+	}
+
+	// registers are left as the hardware leaves them:
+	regs[2] = byte(aSrc)
+	regs[3] = byte(aSrc >> 8)
+	regs[5] = 0
+	regs[6] = 0
+
+	//if h.s.Logger != nil {
+	//	fmt.Fprintf(h.s.Logger, "DMA[%d]  stop: $%06x -> $%04x [$%05x]\n", ch, aSrc, bDestAddr, siz)
+	//}
+}
+
+type HWIO struct {
+	s *System
+
+	DMARegs [8]DMARegs
+	DMA     [8]DMAChannel
+	HDMAEN  byte // channels RunHDMAFrame walks
+
+	PPU struct {
+		incrMode      bool   // false = increment after $2118, true = increment after $2119
+		incrAmt       uint16 // 1, 32, or 128
+		addrRemapping byte
+		addr          uint16
+		vramLatch     uint16 // VRAM word prefetched for $2139/$213A reads
+
+		cgAddr  uint16 // CGRAM byte address; the low bit selects the low/high byte of a color
+		cgLatch byte   // low byte of a color held until its high byte is written
+
+		oamAddrReload uint16 // OAMADDH:OAMADDL word address
+		oamAddr       uint16 // OAM byte address
+		oamLatch      byte   // even byte of a low table word held until the odd byte is written
+	}
+
+	PPURegs PPURegs
+	CPUIO   CPUIO
+	APU     APU
+	Joypad  Joypad
+
+	unimplemented *unimplementedReadCounts
+
+	// mapped to $5000-$7FFF
+	Dyn [0x3000]byte
+}
+
+func (h *HWIO) Reset() {
+	h.DMARegs = [8]DMARegs{}
+	h.DMA = [8]DMAChannel{}
+	h.HDMAEN = 0
+	h.PPU.incrMode = false
+	h.PPU.incrAmt = 0
+	h.PPU.addrRemapping = 0
+	h.PPU.addr = 0
+	h.PPU.vramLatch = 0
+	h.PPU.cgAddr = 0
+	h.PPU.cgLatch = 0
+	h.PPU.oamAddrReload = 0
+	h.PPU.oamAddr = 0
+	h.PPU.oamLatch = 0
+	h.PPURegs = PPURegs{}
+	h.CPUIO = CPUIO{}
+	h.APU = APU{}
+	h.Joypad = Joypad{}
+	h.Dyn = [0x3000]byte{}
+}
+
+func (h *HWIO) Read(address uint32) (value byte) {
+	offs := address & 0xFFFF
+	if offs >= 0x5000 {
+		value = h.Dyn[offs-0x5000]
+		return
+	}
+
+	if offs&0xFFC0 == 0x2140 {
+		// APUIO0 .. APUIO3, mirrored up to $217F:
+		value = h.APU.read(offs & 3)
+		return
+	}
+
+	if offs == 0x2138 {
+		// OAMDATAREAD:
+		value = h.s.OAM[oamIndex(h.PPU.oamAddr)]
+		h.PPU.oamAddr = (h.PPU.oamAddr + 1) & 0x3FF
+		return
+	}
+
+	if offs == 0x2139 {
+		// VMDATALREAD: returns the prefetched word; the prefetch is refilled before the address increments
+		value = byte(h.PPU.vramLatch)
+		if !h.PPU.incrMode {
+			h.vramPrefetch()
+			h.PPU.addr += h.PPU.incrAmt
+		}
+		return
+	}
+	if offs == 0x213A {
+		// VMDATAHREAD:
+		value = byte(h.PPU.vramLatch >> 8)
+		if h.PPU.incrMode {
+			h.vramPrefetch()
+			h.PPU.addr += h.PPU.incrAmt
+		}
+		return
+	}
+
+	if offs == 0x213B {
+		// CGDATAREAD: low byte then high byte of a color; bit 7 of the high byte is open bus:
+		value = h.s.CGRAM[h.PPU.cgAddr&0x1FF]
+		if h.PPU.cgAddr&1 != 0 {
+			value &= 0x7F
+		}
+		h.PPU.cgAddr = (h.PPU.cgAddr + 1) & 0x1FF
+		return
+	}
+
+	var ok bool
+	if value, ok = h.readCPUIO(offs); ok {
+		return
+	}
+
+	h.unimplementedRead(offs)
+	//if h.s.Logger != nil {
+	//	fmt.Fprintf(h.s.Logger, "hwio[$%04x] -> $%02x\n", offs, value)
+	//}
+	return
+}
+
+func (h *HWIO) Write(address uint32, value byte) {
+	offs := address & 0xFFFF
+
+	if offs == 0x4200 {
+		// NMITIMEN
+		return
+	}
+
+	if h.writeCPUIO(offs, value) {
+		return
+	}
+
+	if offs == 0x420b {
+		// MDMAEN:
+		hdmaen := value
+		//if h.s.Logger != nil {
+		//	fmt.Fprintf(h.s.Logger, "hwio[$%04x] <- $%02x DMA start\n", offs, hdmaen)
+		//}
+		// execute DMA transfers from channels 0..7 in order:
+		for c := range h.DMA {
+			if hdmaen&(1<<c) == 0 {
+				continue
+			}
+
+			// channel enabled:
+			h.DMA[c].Transfer(&h.DMARegs[c], c, h)
+		}
+		//if h.s.Logger != nil {
+		//	fmt.Fprintf(h.s.Logger, "hwio[$%04x] <- $%02x DMA end\n", offs, hdmaen)
+		//}
+		return
+	}
+	if offs == 0x420c {
+		// HDMAEN: there are no scanlines to run HDMA on; RunHDMAFrame walks the enabled channels' tables
+		h.HDMAEN = value
+		return
+	}
+	if offs&0xFF00 == 0x4300 {
+		// DMA registers:
+		ch := (offs & 0x00F0) >> 4
+		if ch <= 7 {
+			reg := offs & 0x000F
+			h.DMARegs[ch][reg] = value
+		}
+
+		//if h.s.Logger != nil {
+		//	fmt.Fprintf(h.s.Logger, "hwio[$%04x] <- $%02x DMA register\n", offs, value)
+		//}
+		return
+	}
+
+	if h.writePPURegs(offs, value) {
+		return
+	}
+	if offs == 0x2102 {
+		// OAMADDL: also reloads the byte address
+		h.PPU.oamAddrReload = h.PPU.oamAddrReload&0x100 | uint16(value)
+		h.PPU.oamAddr = h.PPU.oamAddrReload << 1
+		return
+	}
+	if offs == 0x2103 {
+		// OAMADDH: bit 0 selects the high table; priority rotation (bit 7) is not emulated
+		h.PPU.oamAddrReload = uint16(value&1)<<8 | h.PPU.oamAddrReload&0xFF
+		h.PPU.oamAddr = h.PPU.oamAddrReload << 1
+		return
+	}
+	if offs == 0x2104 {
+		// OAMDATA: low table words are written in pairs; the high table is written a byte at a time
+		a := h.PPU.oamAddr
+		if a >= 0x200 {
+			h.s.OAM[oamIndex(a)] = value
+		} else if a&1 == 0 {
+			h.PPU.oamLatch = value
+		} else {
+			h.s.OAM[a&^1] = h.PPU.oamLatch
+			h.s.OAM[a] = value
+		}
+		h.PPU.oamAddr = (a + 1) & 0x3FF
+		return
+	}
+	if offs == 0x2121 {
+		// CGADD: color number; also resets the low/high byte flip-flop
+		h.PPU.cgAddr = uint16(value) << 1
+		return
+	}
+	if offs == 0x2122 {
+		// CGDATA: the low byte is latched and written together with the high byte
+		if h.PPU.cgAddr&1 == 0 {
+			h.PPU.cgLatch = value
+		} else {
+			h.s.CGRAM[h.PPU.cgAddr&^1] = h.PPU.cgLatch
+			h.s.CGRAM[h.PPU.cgAddr] = value & 0x7F
+		}
+		h.PPU.cgAddr = (h.PPU.cgAddr + 1) & 0x1FF
+		return
+	}
+
+	// PPU:
+	if offs == 0x2115 {
+		// VMAIN = o---mmii
+		h.PPU.incrMode = value&0x80 != 0
+		switch value & 3 {
+		case 0:
+			h.PPU.incrAmt = 1
+			break
+		case 1:
+			h.PPU.incrAmt = 32
+			break
+		default:
+			h.PPU.incrAmt = 128
+			break
+		}
+		h.PPU.addrRemapping = (value & 0x0C) >> 2
+		//if h.s.Logger != nil {
+		//	fmt.Fprintf(h.s.Logger, "PC=$%06x\n", h.s.GetPC())
+		//	fmt.Fprintf(h.s.Logger, "VMAIN = $%02x\n", value)
+		//}
+		return
+	}
+	if offs == 0x2116 {
+		// VMADDL
+		h.PPU.addr = uint16(value) | h.PPU.addr&0xFF00
+		h.vramPrefetch()
+		//if h.s.Logger != nil {
+		//	fmt.Fprintf(h.s.Logger, "PC=$%06x\n", h.s.GetPC())
+		//	fmt.Fprintf(h.s.Logger, "VMADDL = $%04x\n", h.PPU.addr)
+		//}
+		return
+	}
+	if offs == 0x2117 {
+		// VMADDH
+		h.PPU.addr = uint16(value)<<8 | h.PPU.addr&0x00FF
+		h.vramPrefetch()
+		//if h.s.Logger != nil {
+		//	fmt.Fprintf(h.s.Logger, "PC=$%06x\n", h.s.GetPC())
+		//	fmt.Fprintf(h.s.Logger, "VMADDH = $%04x\n", h.PPU.addr)
+		//}
+		return
+	}
+	if offs == 0x2118 {
+		// VMDATAL
+		h.s.writeVRAM(h.vramAddr()<<1, value)
+		if h.PPU.incrMode == false {
+			h.PPU.addr += h.PPU.incrAmt
+		}
+		return
+	}
+	if offs == 0x2119 {
+		// VMDATAH
+		h.s.writeVRAM((h.vramAddr()<<1)+1, value)
+		if h.PPU.incrMode == true {
+			h.PPU.addr += h.PPU.incrAmt
+		}
+		return
+	}
+
+	// APU:
+	if offs&0xFFC0 == 0x2140 {
+		// APUIO0 .. APUIO3, mirrored up to $217F:
+		h.APU.write(offs&3, value)
+		return
+	}
+
+	if h.s.Logger != nil {
+		fmt.Fprintf(h.s.Logger, "hwio[$%04x] <- $%02x\n", offs, value)
+	}
+}
+
+// vramAddr applies the VMAIN address remapping to the VRAM word address; each mode rotates the low
+// 8, 9 or 10 bits left by 3 so that 2bpp, 4bpp and 8bpp bitplanes are written in sequence:
+func (h *HWIO) vramAddr() uint16 {
+	a := h.PPU.addr
+	switch h.PPU.addrRemapping {
+	case 1:
+		// aaaaaaaaBBBccccc -> aaaaaaaacccccBBB
+		return a&0xFF00 | a<<3&0x00F8 | a>>5&7
+	case 2:
+		// aaaaaaaBBBcccccc -> aaaaaaaccccccBBB
+		return a&0xFE00 | a<<3&0x01F8 | a>>6&7
+	case 3:
+		// aaaaaaBBBccccccc -> aaaaaacccccccBBB
+		return a&0xFC00 | a<<3&0x03F8 | a>>7&7
+	}
+	return a
+}
+
+// vramPrefetch loads the word at the current VRAM address into the read latch:
+func (h *HWIO) vramPrefetch() {
+	a := h.vramAddr() << 1
+	h.PPU.vramLatch = uint16(h.s.readVRAM(a)) | uint16(h.s.readVRAM(a+1))<<8
+}
+
+// oamIndex maps the 10-bit OAM byte address to OAM; $220-$3FF mirror the 32-byte high table:
+func oamIndex(a uint16) uint16 {
+	if a >= 0x200 {
+		return 0x200 | a&0x1F
+	}
+	return a
+}
+
+func (h *HWIO) Shutdown() {
+}
+
+func (h *HWIO) Size() uint32 {
+	return 0x10000
+}
+
+func (h *HWIO) Clear() {
+}
+
+func (h *HWIO) Dump(address uint32) []byte {
+	return nil
+}
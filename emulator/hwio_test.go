@@ -0,0 +1,359 @@
+package emulator
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestSystem(t *testing.T) *System {
+	t.Helper()
+	s := &System{}
+	if err := s.InitEmulator(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// dma programs channel ch through its $43x0-$43x6 registers and starts it with MDMAEN:
+func dma(h *HWIO, ch int, ctrl, dest byte, src uint32, size uint16) {
+	base := uint32(0x4300) | uint32(ch)<<4
+	h.Write(base+0, ctrl)
+	h.Write(base+1, dest)
+	h.Write(base+2, byte(src))
+	h.Write(base+3, byte(src>>8))
+	h.Write(base+4, byte(src>>16))
+	h.Write(base+5, byte(size))
+	h.Write(base+6, byte(size>>8))
+	h.Write(0x420B, 1<<ch)
+}
+
+func checkBytes(t *testing.T, what string, got, want []byte) {
+	t.Helper()
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s = % 02x; want % 02x", what, got, want)
+	}
+}
+
+func TestDMAModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  byte
+		dest  byte
+		setup func(h *HWIO)
+		src   []byte
+		check func(t *testing.T, s *System)
+	}{
+		{
+			name:  "mode 0 to CGDATA",
+			mode:  0,
+			dest:  0x22,
+			setup: func(h *HWIO) { h.Write(0x2121, 0x10) },
+			src:   []byte{0x34, 0x12, 0x78, 0x56},
+			check: func(t *testing.T, s *System) {
+				checkBytes(t, "CGRAM[$20:$24]", s.CGRAM[0x20:0x24], []byte{0x34, 0x12, 0x78, 0x56})
+			},
+		},
+		{
+			name:  "mode 1 to VMDATA",
+			mode:  1,
+			dest:  0x18,
+			setup: func(h *HWIO) { h.Write(0x2115, 0x80); h.Write(0x2116, 0x00); h.Write(0x2117, 0x10) },
+			src:   []byte{1, 2, 3, 4},
+			check: func(t *testing.T, s *System) {
+				checkBytes(t, "VRAM[$2000:$2004]", s.VRAM[0x2000:0x2004], []byte{1, 2, 3, 4})
+			},
+		},
+		{
+			name:  "mode 2 to OAMDATA",
+			mode:  2,
+			dest:  0x04,
+			setup: func(h *HWIO) { h.Write(0x2102, 0x02); h.Write(0x2103, 0x00) },
+			src:   []byte{0x10, 0x20, 0x30, 0x40},
+			check: func(t *testing.T, s *System) {
+				checkBytes(t, "OAM[$04:$08]", s.OAM[0x04:0x08], []byte{0x10, 0x20, 0x30, 0x40})
+			},
+		},
+		{
+			name: "mode 3 to CGADD, CGADD, CGDATA, CGDATA",
+			mode: 3,
+			dest: 0x21,
+			src:  []byte{5, 5, 0x34, 0x12, 6, 6, 0x78, 0x56},
+			check: func(t *testing.T, s *System) {
+				checkBytes(t, "CGRAM[$0a:$0e]", s.CGRAM[0x0a:0x0e], []byte{0x34, 0x12, 0x78, 0x56})
+			},
+		},
+		{
+			name:  "mode 4 to VMADDL, VMADDH, VMDATAL, VMDATAH",
+			mode:  4,
+			dest:  0x16,
+			setup: func(h *HWIO) { h.Write(0x2115, 0x80) },
+			src:   []byte{0x00, 0x10, 0xAA, 0xBB, 0x00, 0x20, 0xCC, 0xDD},
+			check: func(t *testing.T, s *System) {
+				checkBytes(t, "VRAM[$2000:$2002]", s.VRAM[0x2000:0x2002], []byte{0xAA, 0xBB})
+				checkBytes(t, "VRAM[$4000:$4002]", s.VRAM[0x4000:0x4002], []byte{0xCC, 0xDD})
+			},
+		},
+		{
+			name:  "mode 5 to VMDATA",
+			mode:  5,
+			dest:  0x18,
+			setup: func(h *HWIO) { h.Write(0x2115, 0x80); h.Write(0x2116, 0x00); h.Write(0x2117, 0x30) },
+			src:   []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			check: func(t *testing.T, s *System) {
+				checkBytes(t, "VRAM[$6000:$6008]", s.VRAM[0x6000:0x6008], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+			},
+		},
+		{
+			name:  "mode 6 to OAMDATA",
+			mode:  6,
+			dest:  0x04,
+			setup: func(h *HWIO) { h.Write(0x2102, 0x00); h.Write(0x2103, 0x01) },
+			src:   []byte{0x11, 0x22},
+			check: func(t *testing.T, s *System) {
+				// the high table is written a byte at a time:
+				checkBytes(t, "OAM[$200:$202]", s.OAM[0x200:0x202], []byte{0x11, 0x22})
+			},
+		},
+		{
+			name: "mode 7 to CGADD, CGADD, CGDATA, CGDATA",
+			mode: 7,
+			dest: 0x21,
+			src:  []byte{0x80, 0x80, 0xFF, 0xFF},
+			check: func(t *testing.T, s *System) {
+				// bit 7 of a color's high byte is not stored:
+				checkBytes(t, "CGRAM[$100:$102]", s.CGRAM[0x100:0x102], []byte{0xFF, 0x7F})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSystem(t)
+			h := &s.HWIO
+			copy(s.WRAM[0x1000:], tt.src)
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+
+			dma(h, 3, tt.mode, tt.dest, 0x7E_1000, uint16(len(tt.src)))
+			tt.check(t, s)
+
+			// the address registers are left past the data and the byte count at zero:
+			regs := &h.DMARegs[3]
+			end := 0x1000 + uint16(len(tt.src))
+			if got := uint16(regs.srcH())<<8 | uint16(regs.srcL()); got != end {
+				t.Errorf("A1T3 = $%04x; want $%04x", got, end)
+			}
+			if regs.sizL() != 0 || regs.sizH() != 0 {
+				t.Errorf("DAS3 = $%02x%02x; want $0000", regs.sizH(), regs.sizL())
+			}
+		})
+	}
+}
+
+func TestDMAFixedSource(t *testing.T) {
+	s := newTestSystem(t)
+	h := &s.HWIO
+	s.WRAM[0x1000] = 0x55
+	h.Write(0x2115, 0x80)
+	h.Write(0x2116, 0x00)
+	h.Write(0x2117, 0x08)
+
+	dma(h, 0, 0x08|1, 0x18, 0x7E_1000, 8)
+
+	checkBytes(t, "VRAM[$1000:$1008]", s.VRAM[0x1000:0x1008], bytes.Repeat([]byte{0x55}, 8))
+	if got := uint16(h.DMARegs[0].srcH())<<8 | uint16(h.DMARegs[0].srcL()); got != 0x1000 {
+		t.Errorf("A1T0 = $%04x; want $1000", got)
+	}
+}
+
+func TestDMADecrementSource(t *testing.T) {
+	s := newTestSystem(t)
+	h := &s.HWIO
+	copy(s.WRAM[0x1000:], []byte{1, 2, 3, 4})
+	h.Write(0x2121, 0)
+
+	dma(h, 0, 0x10|0, 0x22, 0x7E_1003, 4)
+
+	checkBytes(t, "CGRAM[0:4]", s.CGRAM[0:4], []byte{4, 3, 2, 1})
+	if got := uint16(h.DMARegs[0].srcH())<<8 | uint16(h.DMARegs[0].srcL()); got != 0x0FFF {
+		t.Errorf("A1T0 = $%04x; want $0fff", got)
+	}
+}
+
+func TestDMASourceWrapsInBank(t *testing.T) {
+	s := newTestSystem(t)
+	h := &s.HWIO
+	s.WRAM[0xFFFF] = 0x11
+	s.WRAM[0x0000] = 0x22
+	h.Write(0x2121, 0)
+
+	dma(h, 0, 0, 0x22, 0x7E_FFFF, 2)
+
+	checkBytes(t, "CGRAM[0:2]", s.CGRAM[0:2], []byte{0x11, 0x22})
+}
+
+func TestDMASizeZeroTransfers64K(t *testing.T) {
+	s := newTestSystem(t)
+	h := &s.HWIO
+	for i := 0; i < 0x10000; i++ {
+		s.WRAM[0x10000+i] = byte(i * 7)
+	}
+	h.Write(0x2115, 0x80)
+	h.Write(0x2116, 0)
+	h.Write(0x2117, 0)
+
+	dma(h, 0, 1, 0x18, 0x7F_0000, 0)
+
+	checkBytes(t, "VRAM", s.VRAM[:], s.WRAM[0x10000:0x20000])
+}
+
+func TestDMAFromPPU(t *testing.T) {
+	t.Run("VMDATAREAD", func(t *testing.T) {
+		s := newTestSystem(t)
+		h := &s.HWIO
+		copy(s.VRAM[0x2000:], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+		h.Write(0x2115, 0x80)
+		h.Write(0x2116, 0x00)
+		h.Write(0x2117, 0x10)
+
+		dma(h, 1, 0x80|1, 0x39, 0x7E_2000, 8)
+
+		// the prefetch is refilled before the address increments so the first word is read twice:
+		checkBytes(t, "WRAM[$2000:$2008]", s.WRAM[0x2000:0x2008], []byte{1, 2, 1, 2, 3, 4, 5, 6})
+	})
+
+	t.Run("CGDATAREAD", func(t *testing.T) {
+		s := newTestSystem(t)
+		h := &s.HWIO
+		copy(s.CGRAM[0x10:], []byte{0x34, 0x12, 0x78, 0x56})
+		h.Write(0x2121, 0x08)
+
+		dma(h, 1, 0x80|0, 0x3B, 0x7E_2000, 4)
+
+		checkBytes(t, "WRAM[$2000:$2004]", s.WRAM[0x2000:0x2004], []byte{0x34, 0x12, 0x78, 0x56})
+	})
+
+	t.Run("OAMDATAREAD", func(t *testing.T) {
+		s := newTestSystem(t)
+		h := &s.HWIO
+		copy(s.OAM[0x08:], []byte{9, 8, 7, 6})
+		h.Write(0x2102, 0x04)
+		h.Write(0x2103, 0x00)
+
+		dma(h, 1, 0x80|0, 0x38, 0x7E_2000, 4)
+
+		checkBytes(t, "WRAM[$2000:$2004]", s.WRAM[0x2000:0x2004], []byte{9, 8, 7, 6})
+	})
+}
+
+func TestRunHDMAFrame(t *testing.T) {
+	s := newTestSystem(t)
+	h := s.HWIO
+
+	// channel 0: COLDATA red 31 for 16 lines then 0; channel 1: CGDATA; channel 2: VMDATAL:
+	copy(s.WRAM[0x1000:], []byte{0x10, 0x3F, 0x10, 0x20, 0x00})
+	copy(s.WRAM[0x1100:], []byte{0x01, 0xFF, 0x00})
+	copy(s.WRAM[0x1200:], []byte{0x01, 0xAA, 0x00})
+	for ch, cfg := range []struct {
+		dest byte
+		src  uint32
+	}{{0x32, 0x7E_1000}, {0x22, 0x7E_1100}, {0x18, 0x7E_1200}} {
+		base := uint32(0x4300) | uint32(ch)<<4
+		h.Write(base+0, 0x00)
+		h.Write(base+1, cfg.dest)
+		h.Write(base+2, byte(cfg.src))
+		h.Write(base+3, byte(cfg.src>>8))
+		h.Write(base+4, byte(cfg.src>>16))
+	}
+	h.Write(0x420C, 0x07)
+
+	regs, ppu := h.DMARegs, h.PPURegs
+	lines := h.RunHDMAFrame()
+
+	if len(lines) != ScanlinesPerFrame {
+		t.Fatalf("got %d lines; want %d", len(lines), ScanlinesPerFrame)
+	}
+	for _, tt := range []struct {
+		line int
+		want uint16
+	}{{0, 0x1F}, {15, 0x1F}, {16, 0x00}, {223, 0x00}} {
+		if got := lines[tt.line].COLDATA; got != tt.want {
+			t.Errorf("line %d COLDATA = $%04x; want $%04x", tt.line, got, tt.want)
+		}
+	}
+
+	if h.DMARegs != regs {
+		t.Errorf("DMA registers not restored")
+	}
+	if h.PPURegs != ppu {
+		t.Errorf("PPU registers not restored")
+	}
+	checkBytes(t, "CGRAM[0:2]", s.CGRAM[0:2], []byte{0, 0})
+	checkBytes(t, "VRAM[0:2]", s.VRAM[0:2], []byte{0, 0})
+}
+
+func TestVRAMRemap(t *testing.T) {
+	tests := []struct {
+		name  string
+		vmain byte
+		addr  uint16
+		want  uint16 // remapped word address
+	}{
+		{"no remap", 0x80, 0x1234, 0x1234},
+		{"8 bit", 0x84, 0x1234, 0x12A1},
+		{"9 bit", 0x88, 0x1234, 0x13A0},
+		{"10 bit", 0x8C, 0x1234, 0x11A4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSystem(t)
+			h := &s.HWIO
+			h.Write(0x2115, tt.vmain)
+			h.Write(0x2116, byte(tt.addr))
+			h.Write(0x2117, byte(tt.addr>>8))
+			h.Write(0x2118, 0x34)
+			h.Write(0x2119, 0x12)
+
+			a := uint32(tt.want) << 1
+			checkBytes(t, "VRAM", s.VRAM[a:a+2], []byte{0x34, 0x12})
+			if h.PPU.addr != tt.addr+1 {
+				t.Errorf("VMADD = $%04x; want $%04x", h.PPU.addr, tt.addr+1)
+			}
+		})
+	}
+}
+
+func TestVRAMReadPrefetch(t *testing.T) {
+	tests := []struct {
+		name  string
+		vmain byte
+		reads []uint32
+		want  []byte
+	}{
+		// the first read returns the word prefetched when VMADD was written; the read that increments the
+		// address refills the prefetch from the address before the increment, as hardware does, so the
+		// second read sees $1000 again:
+		{"low byte increments", 0x00, []uint32{0x2139, 0x2139, 0x2139}, []byte{0x11, 0xFF, 0x22}},
+		{"high byte increments", 0x80, []uint32{0x2139, 0x213A, 0x2139, 0x213A, 0x2139}, []byte{0x11, 0xA1, 0xFF, 0xA1, 0x22}},
+		{"low byte without increment", 0x80, []uint32{0x2139, 0x2139}, []byte{0x11, 0x11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSystem(t)
+			h := &s.HWIO
+			copy(s.VRAM[0x2000:], []byte{0x11, 0xA1, 0x22, 0xA2, 0x33, 0xA3})
+			h.Write(0x2115, tt.vmain)
+			h.Write(0x2116, 0x00)
+			h.Write(0x2117, 0x10)
+
+			// VRAM changed after the prefetch is not seen until the next one:
+			s.VRAM[0x2000] = 0xFF
+			got := make([]byte, len(tt.reads))
+			for i, r := range tt.reads {
+				got[i] = h.Read(r)
+			}
+			checkBytes(t, "reads", got, tt.want)
+		})
+	}
+}
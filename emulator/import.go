@@ -0,0 +1,161 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ImportRAMDumps copies raw memory dumps, as exported by an emulator's memory viewer, over the system's WRAM,
+// VRAM, CGRAM and SRAM; empty paths are skipped. WRAM, VRAM and CGRAM dumps must be complete; an SRAM dump may
+// be smaller than SRAM:
+func (s *System) ImportRAMDumps(wramPath, vramPath, cgramPath, sramPath string) (err error) {
+	dumps := []struct {
+		path  string
+		mem   []byte
+		exact bool
+	}{
+		{wramPath, s.WRAM[:], true},
+		{vramPath, s.VRAM[:], true},
+		{cgramPath, s.CGRAM[:], true},
+		{sramPath, s.SRAM[:], false},
+	}
+
+	for _, d := range dumps {
+		if d.path == "" {
+			continue
+		}
+
+		var b []byte
+		if b, err = os.ReadFile(d.path); err != nil {
+			return
+		}
+		if len(b) > len(d.mem) || (d.exact && len(b) != len(d.mem)) {
+			return fmt.Errorf("%s: dump is $%x bytes; expected $%x", d.path, len(b), len(d.mem))
+		}
+		copy(d.mem, b)
+	}
+	return
+}
+
+// ImportStateFile loads a save state into the system, detecting its format: this program's own save states and
+// snes9x freeze files (.frz, .000 etc., optionally gzip compressed) are supported. bsnes save states are
+// recognised but not supported; export raw dumps from its memory editor for ImportRAMDumps instead:
+func (s *System) ImportStateFile(path string) (err error) {
+	var b []byte
+	if b, err = os.ReadFile(path); err != nil {
+		return
+	}
+
+	// snes9x compresses its freeze files with gzip by default:
+	if len(b) >= 2 && b[0] == 0x1F && b[1] == 0x8B {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(b, []byte(stateMagic)):
+		err = s.LoadState(bytes.NewReader(b))
+	case bytes.HasPrefix(b, []byte(snes9xMagic)):
+		err = s.importSnes9x(b)
+	case bytes.HasPrefix(b, []byte("BST")):
+		err = fmt.Errorf("bsnes save states are not supported; export WRAM, VRAM and CGRAM dumps from its memory editor instead")
+	default:
+		err = fmt.Errorf("unrecognised save state format")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return
+}
+
+// snes9x freeze files start with "#!s9xsnp:nnnn\n" and continue with blocks each headed by a 3 letter name, a
+// 6 digit decimal length and colons, e.g. "RAM:131072:":
+const snes9xMagic = "#!s9xsnp"
+
+// importSnes9x copies WRAM, VRAM, SRAM and the CPU registers from a snes9x freeze file. The PPU block's layout
+// differs between snes9x versions so CGRAM and the IO registers are left alone; rooms draw with the palette
+// the game keeps in WRAM unless CGRAM is asked for:
+func (s *System) importSnes9x(b []byte) (err error) {
+	r := bufio.NewReader(bytes.NewReader(b))
+	if _, err = r.ReadString('\n'); err != nil {
+		return fmt.Errorf("snes9x: %w", err)
+	}
+
+	found := map[string]bool{}
+	hdr := make([]byte, 11)
+	for {
+		if _, err = io.ReadFull(r, hdr); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return fmt.Errorf("snes9x: %w", err)
+		}
+
+		name := string(hdr[:3])
+		var n uint64
+		if hdr[3] != ':' || hdr[10] != ':' {
+			return fmt.Errorf("snes9x: bad block header %q", hdr)
+		}
+		if n, err = strconv.ParseUint(string(hdr[4:10]), 10, 32); err != nil {
+			return fmt.Errorf("snes9x: block %s: bad length %q", name, hdr[4:10])
+		}
+
+		data := make([]byte, n)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("snes9x: block %s: %w", name, err)
+		}
+		found[name] = true
+
+		switch name {
+		case "RAM":
+			copy(s.WRAM[:], data)
+		case "VRA":
+			copy(s.VRAM[:], data)
+		case "SRA":
+			copy(s.SRAM[:], data)
+		case "REG":
+			if err = s.importSnes9xRegisters(data); err != nil {
+				return
+			}
+		}
+	}
+
+	for _, name := range []string{"RAM", "VRA"} {
+		if !found[name] {
+			return fmt.Errorf("snes9x: missing %s block", name)
+		}
+	}
+	return
+}
+
+// importSnes9xRegisters sets the CPU registers from the REG block: PB, DB, then P, A, D, S, X, Y and PC as
+// big-endian words; bit 8 of P is the emulation flag:
+func (s *System) importSnes9xRegisters(data []byte) (err error) {
+	if len(data) < 16 {
+		return fmt.Errorf("snes9x: REG block is %d bytes; expected 16", len(data))
+	}
+
+	word := func(i int) uint16 { return binary.BigEndian.Uint16(data[i:]) }
+	p := word(2)
+	r := CPURegs{
+		PC: uint32(data[0])<<16 | uint32(word(14)),
+		A:  word(4), X: word(10), Y: word(12),
+		S: word(8), D: word(6),
+		DB: data[1],
+		P:  byte(p),
+		E:  p&0x100 != 0,
+	}
+	r.apply(&s.CPU)
+	return
+}
@@ -0,0 +1,121 @@
+package emulator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// joypad buttons as auto-read into JOY1H:JOY1L ($4219:$4218):
+const (
+	ButtonR      uint16 = 1 << 4
+	ButtonL      uint16 = 1 << 5
+	ButtonX      uint16 = 1 << 6
+	ButtonA      uint16 = 1 << 7
+	ButtonRight  uint16 = 1 << 8
+	ButtonLeft   uint16 = 1 << 9
+	ButtonDown   uint16 = 1 << 10
+	ButtonUp     uint16 = 1 << 11
+	ButtonStart  uint16 = 1 << 12
+	ButtonSelect uint16 = 1 << 13
+	ButtonY      uint16 = 1 << 14
+	ButtonB      uint16 = 1 << 15
+)
+
+var buttonNames = map[string]uint16{
+	"none":   0,
+	"r":      ButtonR,
+	"l":      ButtonL,
+	"x":      ButtonX,
+	"a":      ButtonA,
+	"right":  ButtonRight,
+	"left":   ButtonLeft,
+	"down":   ButtonDown,
+	"up":     ButtonUp,
+	"start":  ButtonStart,
+	"select": ButtonSelect,
+	"y":      ButtonY,
+	"b":      ButtonB,
+}
+
+// JoypadInput holds the same buttons down for a number of frames:
+type JoypadInput struct {
+	Buttons uint16
+	Frames  int
+}
+
+// ParseJoypadScript parses a comma separated list of buttons:frames steps with buttons joined by "+",
+// e.g. "right:30,up+b:10,none:5":
+func ParseJoypadScript(s string) (script []JoypadInput, err error) {
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		i := strings.LastIndexByte(step, ':')
+		if i < 0 {
+			err = fmt.Errorf("joypad: step %q: expected buttons:frames", step)
+			return
+		}
+
+		in := JoypadInput{}
+		if in.Frames, err = strconv.Atoi(step[i+1:]); err != nil || in.Frames < 1 {
+			err = fmt.Errorf("joypad: step %q: bad frame count", step)
+			return
+		}
+		for _, name := range strings.Split(step[:i], "+") {
+			b, ok := buttonNames[strings.ToLower(strings.TrimSpace(name))]
+			if !ok {
+				err = fmt.Errorf("joypad: step %q: unknown button %q", step, name)
+				return
+			}
+			in.Buttons |= b
+		}
+
+		script = append(script, in)
+	}
+	return
+}
+
+// Joypad is controller 1, fed one frame at a time from a scripted input sequence:
+type Joypad struct {
+	Script []JoypadInput
+
+	step, frame int    // position in Script
+	Buttons     uint16 // buttons latched by the last auto-read
+
+	strobe bool   // $4016 bit 0
+	serial uint16 // shift register read a bit at a time through $4016
+}
+
+// NextFrame latches the next frame's buttons as the auto-joypad read at the start of vblank would; it returns
+// false once the script is used up:
+func (j *Joypad) NextFrame() bool {
+	for j.step < len(j.Script) && j.frame >= j.Script[j.step].Frames {
+		j.step++
+		j.frame = 0
+	}
+	if j.step >= len(j.Script) {
+		j.Buttons = 0
+		return false
+	}
+
+	j.Buttons = j.Script[j.step].Buttons
+	j.frame++
+	j.serial = j.Buttons
+	return true
+}
+
+func (j *Joypad) writeStrobe(value byte) {
+	j.strobe = value&1 != 0
+	if j.strobe {
+		j.serial = j.Buttons
+	}
+}
+
+// readSerial returns the next button bit starting from B; 1s follow once all 16 are read:
+func (j *Joypad) readSerial() (value byte) {
+	if j.strobe {
+		return byte(j.Buttons >> 15)
+	}
+	value = byte(j.serial >> 15)
+	j.serial = j.serial<<1 | 1
+	return
+}
@@ -0,0 +1,57 @@
+package emulator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJoypadScript(t *testing.T) {
+	tests := []struct {
+		script  string
+		want    []JoypadInput
+		wantErr string
+	}{
+		{"right:30", []JoypadInput{{ButtonRight, 30}}, ""},
+		{"right:30, Up+B:10 ,none:5", []JoypadInput{{ButtonRight, 30}, {ButtonUp | ButtonB, 10}, {0, 5}}, ""},
+		{"start+select+l+r:1", []JoypadInput{{ButtonStart | ButtonSelect | ButtonL | ButtonR, 1}}, ""},
+		{"right", nil, "expected buttons:frames"},
+		{"right:0", nil, "bad frame count"},
+		{"right:x", nil, "bad frame count"},
+		{"turbo:3", nil, `unknown button "turbo"`},
+		{"", nil, "expected buttons:frames"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			got, err := ParseJoypadScript(tt.script)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoypadNextFrame(t *testing.T) {
+	j := &Joypad{Script: []JoypadInput{{ButtonA, 2}, {ButtonB | ButtonUp, 1}}}
+
+	for i, want := range []uint16{ButtonA, ButtonA, ButtonB | ButtonUp} {
+		if !j.NextFrame() {
+			t.Fatalf("frame %d: script ended early", i)
+		}
+		if j.Buttons != want {
+			t.Errorf("frame %d: buttons $%04x; want $%04x", i, j.Buttons, want)
+		}
+	}
+	if j.NextFrame() || j.Buttons != 0 {
+		t.Errorf("script not ended: buttons $%04x", j.Buttons)
+	}
+}
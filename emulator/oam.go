@@ -0,0 +1,39 @@
+package emulator
+
+// Sprite is one decoded OAM entry:
+type Sprite struct {
+	X        int16  // -256..255
+	Y        uint8  // top line; sprites wrap around from 239 to the top
+	Tile     uint16 // 9-bit character number; bit 8 selects the second name table
+	Palette  uint8  // 0..7 selecting CGRAM colors 128+16*Palette
+	Priority uint8  // 0..3
+	HFlip    bool
+	VFlip    bool
+	Large    bool // drawn at the large size selected by OBSEL instead of the small one
+}
+
+// Sprites decodes all 128 entries of OAM:
+func (s *System) Sprites() (sprites [128]Sprite) {
+	oam := s.OAM
+	for i := range sprites {
+		lo := oam[i<<2 : i<<2+4]
+		hi := oam[0x200+i>>2] >> ((i & 3) << 1)
+
+		x := int16(lo[0]) | int16(hi&1)<<8
+		if x >= 0x100 {
+			x -= 0x200
+		}
+
+		sprites[i] = Sprite{
+			X:        x,
+			Y:        lo[1],
+			Tile:     uint16(lo[2]) | uint16(lo[3]&1)<<8,
+			Palette:  (lo[3] >> 1) & 7,
+			Priority: (lo[3] >> 4) & 3,
+			HFlip:    lo[3]&0x40 != 0,
+			VFlip:    lo[3]&0x80 != 0,
+			Large:    hi&2 != 0,
+		}
+	}
+	return
+}
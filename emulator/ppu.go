@@ -0,0 +1,199 @@
+package emulator
+
+// screen designation and color math layer bits shared by TM, TS, TMW, TSW and CGADSUB:
+const (
+	LayerBG1      = 1 << 0
+	LayerBG2      = 1 << 1
+	LayerBG3      = 1 << 2
+	LayerBG4      = 1 << 3
+	LayerOBJ      = 1 << 4
+	LayerBackdrop = 1 << 5 // CGADSUB only
+)
+
+// PPURegs holds the PPU's background, screen designation and color math registers as last written:
+type PPURegs struct {
+	INIDISP byte      // $2100
+	BGMODE  byte      // $2105
+	MOSAIC  byte      // $2106
+	BGSC    [4]byte   // $2107-$210A BGnSC tilemap address and size
+	BGNBA   [2]byte   // $210B-$210C BG12NBA, BG34NBA character data addresses
+	BGHOFS  [4]uint16 // $210D, $210F, $2111, $2113
+	BGVOFS  [4]uint16 // $210E, $2110, $2112, $2114
+	W12SEL  byte      // $2123
+	W34SEL  byte      // $2124
+	WOBJSEL byte      // $2125
+	WH      [4]byte   // $2126-$2129 window 1 left, right, window 2 left, right
+	WBGLOG  byte      // $212A
+	WOBJLOG byte      // $212B
+	TM      byte      // $212C main screen layers
+	TS      byte      // $212D sub screen layers
+	TMW     byte      // $212E main screen window mask layers
+	TSW     byte      // $212F sub screen window mask layers
+	CGWSEL  byte      // $2130
+	CGADSUB byte      // $2131
+	COLDATA uint16    // $2132 fixed color as BGR15
+
+	bgofsLatch  byte // previous byte written to any BGnxOFS register
+	bghofsLatch byte // previous byte written to any BGnHOFS register
+}
+
+// window numbers for Window; BG1-BG4 and OBJ follow the layer bit order:
+const (
+	WindowBG1   = 0
+	WindowBG2   = 1
+	WindowBG3   = 2
+	WindowBG4   = 3
+	WindowOBJ   = 4
+	WindowColor = 5
+)
+
+// Window reports whether screen column x is inside the combined window 1 and 2 area for window n:
+func (r *PPURegs) Window(n int, x int) bool {
+	var sel, logic byte
+	switch {
+	case n < 2:
+		sel, logic = r.W12SEL>>(4*n), r.WBGLOG>>(2*n)
+	case n < 4:
+		sel, logic = r.W34SEL>>(4*(n-2)), r.WBGLOG>>(2*n)
+	default:
+		sel, logic = r.WOBJSEL>>(4*(n-4)), r.WOBJLOG>>(2*(n-4))
+	}
+
+	// per window: bit 0 = invert, bit 1 = enable
+	w1 := (int(r.WH[0]) <= x && x <= int(r.WH[1])) != (sel&1 != 0)
+	w2 := (int(r.WH[2]) <= x && x <= int(r.WH[3])) != (sel&4 != 0)
+	switch sel & 0x0A {
+	case 0x00:
+		return false
+	case 0x02:
+		return w1
+	case 0x08:
+		return w2
+	}
+	switch logic & 3 {
+	case 0:
+		return w1 || w2
+	case 1:
+		return w1 && w2
+	case 2:
+		return w1 != w2
+	default:
+		return w1 == w2
+	}
+}
+
+// WindowMask returns which of the given screen layers are masked out by their windows at screen column x:
+func (r *PPURegs) WindowMask(layers byte, x int) (masked byte) {
+	for n := WindowBG1; n <= WindowOBJ; n++ {
+		if layers&(1<<n) != 0 && r.Window(n, x) {
+			masked |= 1 << n
+		}
+	}
+	return
+}
+
+// colorWindowRegion decodes CGWSEL's 2-bit never/outside/inside/always settings at screen column x; with no
+// screen position (x < 0) the window-dependent settings are treated as never:
+func (r *PPURegs) colorWindowRegion(mode byte, x int) bool {
+	switch mode & 3 {
+	case 0:
+		return false
+	case 3:
+		return true
+	}
+	if x < 0 {
+		return false
+	}
+	return r.Window(WindowColor, x) == (mode&3 == 2)
+}
+
+// ColorMathAt reports whether color math applies to pixels of the given main screen layer at screen column x:
+func (r *PPURegs) ColorMathAt(layer byte, x int) bool {
+	return r.CGADSUB&layer != 0 && !r.colorWindowRegion(r.CGWSEL>>4, x)
+}
+
+// ClipToBlackAt reports whether the main screen is forced to black at screen column x:
+func (r *PPURegs) ClipToBlackAt(x int) bool {
+	return r.colorWindowRegion(r.CGWSEL>>6, x)
+}
+
+// ColorMathEnabled reports whether color math applies to pixels of the given main screen layer regardless of
+// screen position:
+func (r *PPURegs) ColorMathEnabled(layer byte) bool {
+	return r.ColorMathAt(layer, -1)
+}
+
+// AddSubscreen reports whether color math uses the sub screen rather than the fixed color:
+func (r *PPURegs) AddSubscreen() bool { return r.CGWSEL&0x02 != 0 }
+
+// Subtract reports whether color math subtracts instead of adds:
+func (r *PPURegs) Subtract() bool { return r.CGADSUB&0x80 != 0 }
+
+// Half reports whether color math results are halved:
+func (r *PPURegs) Half() bool { return r.CGADSUB&0x40 != 0 }
+
+func (h *HWIO) writePPURegs(offs uint32, value byte) (ok bool) {
+	r := &h.PPURegs
+	ok = true
+	switch {
+	case offs == 0x2100:
+		r.INIDISP = value
+	case offs == 0x2105:
+		r.BGMODE = value
+	case offs == 0x2106:
+		r.MOSAIC = value
+	case offs >= 0x2107 && offs <= 0x210A:
+		r.BGSC[offs-0x2107] = value
+	case offs == 0x210B || offs == 0x210C:
+		r.BGNBA[offs-0x210B] = value
+	case offs >= 0x210D && offs <= 0x2114:
+		// BGnHOFS and BGnVOFS are written twice, low byte first, through latches shared by all four BGs:
+		n := (offs - 0x210D) >> 1
+		if (offs-0x210D)&1 == 0 {
+			r.BGHOFS[n] = (uint16(value)<<8 | uint16(r.bgofsLatch&^7) | uint16(r.bghofsLatch&7)) & 0x3FF
+			r.bghofsLatch = value
+		} else {
+			r.BGVOFS[n] = (uint16(value)<<8 | uint16(r.bgofsLatch)) & 0x3FF
+		}
+		r.bgofsLatch = value
+	case offs == 0x2123:
+		r.W12SEL = value
+	case offs == 0x2124:
+		r.W34SEL = value
+	case offs == 0x2125:
+		r.WOBJSEL = value
+	case offs >= 0x2126 && offs <= 0x2129:
+		r.WH[offs-0x2126] = value
+	case offs == 0x212A:
+		r.WBGLOG = value
+	case offs == 0x212B:
+		r.WOBJLOG = value
+	case offs == 0x212C:
+		r.TM = value
+	case offs == 0x212D:
+		r.TS = value
+	case offs == 0x212E:
+		r.TMW = value
+	case offs == 0x212F:
+		r.TSW = value
+	case offs == 0x2130:
+		r.CGWSEL = value
+	case offs == 0x2131:
+		r.CGADSUB = value
+	case offs == 0x2132:
+		// COLDATA: bits 5-7 select which of red, green and blue take the intensity in bits 0-4
+		c := uint16(value & 0x1F)
+		if value&0x20 != 0 {
+			r.COLDATA = r.COLDATA&^0x001F | c
+		}
+		if value&0x40 != 0 {
+			r.COLDATA = r.COLDATA&^0x03E0 | c<<5
+		}
+		if value&0x80 != 0 {
+			r.COLDATA = r.COLDATA&^0x7C00 | c<<10
+		}
+	default:
+		ok = false
+	}
+	return
+}
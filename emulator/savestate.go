@@ -0,0 +1,309 @@
+package emulator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// save state files start with this magic and format version; bump stateVersion whenever the field list in
+// the saveState methods below changes:
+const (
+	stateMagic   = "MGSS"
+	stateVersion = 1
+)
+
+// stateCodec writes fields to w or reads them from r so that a single list of fields describes both directions
+// of the save state format; the first error stops all further transfers:
+type stateCodec struct {
+	w   io.Writer
+	r   io.Reader
+	err error
+	buf [8]byte
+}
+
+func (c *stateCodec) bytes(b []byte) {
+	if c.err != nil {
+		return
+	}
+	if c.w != nil {
+		_, c.err = c.w.Write(b)
+	} else {
+		_, c.err = io.ReadFull(c.r, b)
+	}
+}
+
+func (c *stateCodec) u8(p *byte) {
+	b := c.buf[:1]
+	b[0] = *p
+	c.bytes(b)
+	*p = b[0]
+}
+
+func (c *stateCodec) u16(p *uint16) {
+	b := c.buf[:2]
+	binary.LittleEndian.PutUint16(b, *p)
+	c.bytes(b)
+	*p = binary.LittleEndian.Uint16(b)
+}
+
+func (c *stateCodec) u32(p *uint32) {
+	b := c.buf[:4]
+	binary.LittleEndian.PutUint32(b, *p)
+	c.bytes(b)
+	*p = binary.LittleEndian.Uint32(b)
+}
+
+func (c *stateCodec) u64(p *uint64) {
+	b := c.buf[:8]
+	binary.LittleEndian.PutUint64(b, *p)
+	c.bytes(b)
+	*p = binary.LittleEndian.Uint64(b)
+}
+
+func (c *stateCodec) int(p *int) {
+	v := uint32(int32(*p))
+	c.u32(&v)
+	*p = int(int32(v))
+}
+
+func (c *stateCodec) flag(p *bool) {
+	var v byte
+	if *p {
+		v = 1
+	}
+	c.u8(&v)
+	*p = v != 0
+}
+
+// SaveState writes the emulated system's CPU registers, memory and IO register state to w. The ROM is not
+// included; only its checksum is, so that the state is only loaded back with the same ROM:
+func (s *System) SaveState(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	c := &stateCodec{w: bw}
+	s.header(c)
+	s.saveState(c)
+	if err = c.err; err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+// LoadState replaces the emulated system's state with one written by SaveState. s must already be set up by
+// InitEmulator or InitEmulatorFrom with the ROM the state was saved with:
+func (s *System) LoadState(r io.Reader) (err error) {
+	c := &stateCodec{r: bufio.NewReader(r)}
+	if err = s.header(c); err != nil {
+		return
+	}
+
+	// load into a copy so that a truncated file leaves s as it was:
+	t := &System{}
+	t.InitMemory()
+	t.CPU = s.CPU
+	t.HWIO = s.HWIO
+	t.saveState(c)
+	if err = c.err; err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+
+	*s.WRAM = *t.WRAM
+	*s.SRAM = *t.SRAM
+	*s.VRAM = *t.VRAM
+	*s.CGRAM = *t.CGRAM
+	*s.OAM = *t.OAM
+	// t's CPU and HWIO started as copies of s's so its bus and hooks carry over; InitFrom rebinds the
+	// instruction table to s:
+	s.CPU.InitFrom(&t.CPU)
+	s.HWIO = t.HWIO
+	return
+}
+
+// SaveStateFile writes the emulated system's state to a file:
+func (s *System) SaveStateFile(path string) (err error) {
+	var f *os.File
+	if f, err = os.Create(path); err != nil {
+		return
+	}
+	if err = s.SaveState(f); err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
+
+// LoadStateFile loads the emulated system's state from a file written by SaveStateFile:
+func (s *System) LoadStateFile(path string) (err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	if err = s.LoadState(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return
+}
+
+func (s *System) header(c *stateCodec) (err error) {
+	magic := []byte(stateMagic)
+	version := uint16(stateVersion)
+	romCRC := crc32.ChecksumIEEE(s.ROM)
+	wantCRC := romCRC
+
+	c.bytes(magic)
+	c.u16(&version)
+	c.u32(&romCRC)
+	if err = c.err; err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+
+	if string(magic) != stateMagic {
+		return fmt.Errorf("state: not a save state file")
+	}
+	if version != stateVersion {
+		return fmt.Errorf("state: unsupported version %d; expected %d", version, stateVersion)
+	}
+	if romCRC != wantCRC {
+		return fmt.Errorf("state: saved with a ROM with CRC32 %08x; this ROM's is %08x", romCRC, wantCRC)
+	}
+	return
+}
+
+func (s *System) saveState(c *stateCodec) {
+	cpu := &s.CPU
+	c.u64(&cpu.AllCycles)
+	c.u8(&cpu.Cycles)
+	c.flag(&cpu.Stopped)
+	c.u8(&cpu.PRK)
+	c.u16(&cpu.PPC)
+	c.u8(&cpu.WDM)
+	c.u16(&cpu.PC)
+	c.u16(&cpu.SP)
+	c.u16(&cpu.RA)
+	c.u16(&cpu.RX)
+	c.u16(&cpu.RY)
+	c.u8(&cpu.RAh)
+	c.u8(&cpu.RAl)
+	c.u8(&cpu.RXl)
+	c.u8(&cpu.RYl)
+	c.u8(&cpu.RDBR)
+	c.u16(&cpu.RD)
+	c.u8(&cpu.RK)
+	for _, f := range []*byte{&cpu.N, &cpu.V, &cpu.M, &cpu.X, &cpu.D, &cpu.I, &cpu.Z, &cpu.C, &cpu.B, &cpu.E} {
+		c.u8(f)
+	}
+	c.u8(&cpu.Interrupt)
+
+	c.bytes(s.WRAM[:])
+	c.bytes(s.SRAM[:])
+	c.bytes(s.VRAM[:])
+	c.bytes(s.CGRAM[:])
+	c.bytes(s.OAM[:])
+
+	s.HWIO.saveState(c)
+}
+
+func (h *HWIO) saveState(c *stateCodec) {
+	for i := range h.DMARegs {
+		c.bytes(h.DMARegs[i][:])
+		c.flag(&h.DMA[i].hdmaDoTransfer)
+		c.flag(&h.DMA[i].hdmaCompleted)
+	}
+	c.u8(&h.HDMAEN)
+
+	p := &h.PPU
+	c.flag(&p.incrMode)
+	c.u16(&p.incrAmt)
+	c.u8(&p.addrRemapping)
+	c.u16(&p.addr)
+	c.u16(&p.vramLatch)
+	c.u16(&p.cgAddr)
+	c.u8(&p.cgLatch)
+	c.u16(&p.oamAddrReload)
+	c.u16(&p.oamAddr)
+	c.u8(&p.oamLatch)
+
+	h.PPURegs.saveState(c)
+	h.CPUIO.saveState(c)
+	h.APU.saveState(c)
+	h.Joypad.saveState(c)
+
+	c.bytes(h.Dyn[:])
+}
+
+func (r *PPURegs) saveState(c *stateCodec) {
+	c.u8(&r.INIDISP)
+	c.u8(&r.BGMODE)
+	c.u8(&r.MOSAIC)
+	c.bytes(r.BGSC[:])
+	c.bytes(r.BGNBA[:])
+	for i := range r.BGHOFS {
+		c.u16(&r.BGHOFS[i])
+		c.u16(&r.BGVOFS[i])
+	}
+	c.u8(&r.W12SEL)
+	c.u8(&r.W34SEL)
+	c.u8(&r.WOBJSEL)
+	c.bytes(r.WH[:])
+	c.u8(&r.WBGLOG)
+	c.u8(&r.WOBJLOG)
+	c.u8(&r.TM)
+	c.u8(&r.TS)
+	c.u8(&r.TMW)
+	c.u8(&r.TSW)
+	c.u8(&r.CGWSEL)
+	c.u8(&r.CGADSUB)
+	c.u16(&r.COLDATA)
+	c.u8(&r.bgofsLatch)
+	c.u8(&r.bghofsLatch)
+}
+
+func (m *CPUIO) saveState(c *stateCodec) {
+	c.u8(&m.wrmpya)
+	c.u16(&m.wrdiv)
+	c.u16(&m.rddiv)
+	c.u16(&m.rdmpy)
+	c.u64(&m.nmiReadFrame)
+	c.u16(&m.hLatch)
+	c.u16(&m.vLatch)
+	c.flag(&m.hLatchHigh)
+	c.flag(&m.vLatchHigh)
+	c.flag(&m.latched)
+}
+
+func (a *APU) saveState(c *stateCodec) {
+	c.u8((*byte)(&a.state))
+	c.bytes(a.in[:])
+	c.bytes(a.out[:])
+	c.u8(&a.counter)
+	c.flag(&a.blockStart)
+}
+
+func (j *Joypad) saveState(c *stateCodec) {
+	n := len(j.Script)
+	c.int(&n)
+	if c.r != nil {
+		if n < 0 || n > 0x10000 {
+			if c.err == nil {
+				c.err = fmt.Errorf("joypad script length %d out of range", n)
+			}
+			return
+		}
+		j.Script = make([]JoypadInput, n)
+	}
+	for i := range j.Script {
+		c.u16(&j.Script[i].Buttons)
+		c.int(&j.Script[i].Frames)
+	}
+
+	c.int(&j.step)
+	c.int(&j.frame)
+	c.u16(&j.Buttons)
+	c.flag(&j.strobe)
+	c.u16(&j.serial)
+}
@@ -0,0 +1,98 @@
+package emulator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	s := newTestSystem(t)
+	s.ROM = make([]byte, 0x8000)
+	s.CPU.PC = 0x8123
+	s.CPU.RK = 0x02
+	s.CPU.RA = 0xBEEF
+	s.WRAM[0x00A0] = 0x12
+	s.VRAM[0x2000] = 0x34
+	s.CGRAM[0x02] = 0x56
+	s.OAM[0x200] = 0x78
+	s.HWIO.Write(0x2115, 0x84)
+	s.HWIO.Write(0x4202, 0x10)
+	s.HWIO.APU.write(0, 0xCC)
+	s.HWIO.Joypad.Script = []JoypadInput{{ButtonA, 3}}
+	s.HWIO.Joypad.NextFrame()
+
+	var buf bytes.Buffer
+	if err := s.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	// load into a fresh system with the same ROM:
+	u := newTestSystem(t)
+	u.ROM = s.ROM
+	if err := u.LoadState(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if u.CPU.PC != 0x8123 || u.CPU.RK != 0x02 || u.CPU.RA != 0xBEEF {
+		t.Errorf("CPU = PC $%02x:%04x A $%04x", u.CPU.RK, u.CPU.PC, u.CPU.RA)
+	}
+	if *u.WRAM != *s.WRAM || *u.VRAM != *s.VRAM || *u.CGRAM != *s.CGRAM || *u.OAM != *s.OAM {
+		t.Errorf("memory differs")
+	}
+	if u.HWIO.PPU.addrRemapping != 1 || u.HWIO.CPUIO.wrmpya != 0x10 || u.HWIO.APU.state != apuIPLTransfer {
+		t.Errorf("IO registers differ")
+	}
+	if u.HWIO.Joypad.Buttons != ButtonA || len(u.HWIO.Joypad.Script) != 1 {
+		t.Errorf("joypad differs")
+	}
+
+	// saving again gives the same bytes:
+	buf.Reset()
+	if err := u.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), saved) {
+		t.Errorf("resaved state differs")
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	s := newTestSystem(t)
+	s.ROM = make([]byte, 0x8000)
+	s.WRAM[0] = 0x99
+	var buf bytes.Buffer
+	if err := s.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	badVersion := append([]byte(nil), saved...)
+	badVersion[4] = 0xFF
+
+	tests := []struct {
+		name    string
+		rom     []byte
+		state   []byte
+		wantErr string
+	}{
+		{"other ROM", append(make([]byte, 0x7FFF), 1), saved, "saved with a ROM with CRC32"},
+		{"not a state", s.ROM, []byte("PATCH\x00\x00\x00\x00\x00\x00"), "not a save state file"},
+		{"unsupported version", s.ROM, badVersion, "unsupported version"},
+		{"truncated", s.ROM, saved[:len(saved)-1], "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestSystem(t)
+			u.ROM = tt.rom
+			err := u.LoadState(bytes.NewReader(tt.state))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v; want %q", err, tt.wantErr)
+			}
+			// a failed load leaves the system as it was:
+			if u.WRAM[0] != 0 {
+				t.Errorf("WRAM modified by a failed load")
+			}
+		})
+	}
+}
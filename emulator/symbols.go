@@ -0,0 +1,159 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbols names bus addresses for traces and diagnostics. Addresses in banks $80-$FD are stored as their
+// $00-$7D mirrors so either form looks up the same symbol:
+type Symbols struct {
+	names  map[uint32]string
+	addrs  []uint32 // sorted keys of names
+	byName map[string]uint32
+}
+
+func NewSymbols() *Symbols {
+	return &Symbols{names: make(map[uint32]string), byName: make(map[string]uint32)}
+}
+
+func symbolAddr(addr uint32) uint32 {
+	if bank := addr >> 16; bank >= 0x80 && bank < 0xFE {
+		addr &^= 0x80_0000
+	}
+	return addr & 0xFF_FFFF
+}
+
+// Add names an address; a later name for the same address replaces the earlier one:
+func (s *Symbols) Add(addr uint32, name string) {
+	addr = symbolAddr(addr)
+	if _, ok := s.names[addr]; !ok {
+		i := sort.Search(len(s.addrs), func(i int) bool { return s.addrs[i] >= addr })
+		s.addrs = append(s.addrs, 0)
+		copy(s.addrs[i+1:], s.addrs[i:])
+		s.addrs[i] = addr
+	}
+	s.names[addr] = name
+	s.byName[name] = addr
+}
+
+// Merge adds all of o's symbols, replacing s's names for the same addresses:
+func (s *Symbols) Merge(o *Symbols) {
+	if o == nil {
+		return
+	}
+	for _, addr := range o.addrs {
+		s.Add(addr, o.names[addr])
+	}
+}
+
+func (s *Symbols) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.addrs)
+}
+
+// Addr returns the address of the named symbol:
+func (s *Symbols) Addr(name string) (addr uint32, ok bool) {
+	if s == nil {
+		return
+	}
+	addr, ok = s.byName[name]
+	return
+}
+
+// Lookup returns the symbol at or closest before addr in the same bank and addr's offset from it:
+func (s *Symbols) Lookup(addr uint32) (name string, offs uint32, ok bool) {
+	if s == nil {
+		return
+	}
+	addr = symbolAddr(addr)
+	i := sort.Search(len(s.addrs), func(i int) bool { return s.addrs[i] > addr }) - 1
+	if i < 0 || s.addrs[i]>>16 != addr>>16 {
+		return
+	}
+	return s.names[s.addrs[i]], addr - s.addrs[i], true
+}
+
+// Format returns "name" or "name+$offs" for addr, or "" when no symbol precedes it in its bank:
+func (s *Symbols) Format(addr uint32) string {
+	name, offs, ok := s.Lookup(addr)
+	if !ok {
+		return ""
+	}
+	if offs == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s+$%x", name, offs)
+}
+
+// LoadSymbolFile reads the labels of a symbol file written by an assembler: WLA DX and asar's --symbols=wla
+// files with their "[labels]" section of "bb:aaaa name" lines, or files of "address name" lines such as bass
+// and no$sns write, where the address is hexadecimal and 6 or 8 digits long. Other sections, comments starting
+// with ";" or "#" and anonymous labels are skipped:
+func LoadSymbolFile(path string) (s *Symbols, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	s = NewSymbols()
+	section := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			section = strings.ToLower(line)
+			continue
+		}
+		if section != "" && section != "[labels]" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		var addr uint32
+		if len(fields) < 2 {
+			err = fmt.Errorf("%s:%d: expected address and name", path, n)
+			return
+		}
+		if addr, err = ParseAddr(fields[0]); err != nil {
+			err = fmt.Errorf("%s:%d: %w", path, n, err)
+			return
+		}
+		if name := fields[1]; !strings.ContainsAny(name[:1], ":+-") {
+			s.Add(addr, name)
+		}
+	}
+	err = sc.Err()
+	return
+}
+
+// ParseAddr parses a hexadecimal bus address written as "$0083D1", "0x0083D1", "0083D1" or "00:83D1"; 8 digit
+// addresses are accepted and truncated to 24 bits:
+func ParseAddr(a string) (addr uint32, err error) {
+	h := strings.TrimPrefix(strings.TrimPrefix(a, "$"), "0x")
+	if i := strings.IndexByte(h, ':'); i >= 0 {
+		var bank, offs uint64
+		if bank, err = strconv.ParseUint(h[:i], 16, 8); err == nil {
+			offs, err = strconv.ParseUint(h[i+1:], 16, 16)
+		}
+		addr = uint32(bank)<<16 | uint32(offs)
+	} else {
+		var v uint64
+		v, err = strconv.ParseUint(h, 16, 32)
+		addr = uint32(v) & 0xFF_FFFF
+	}
+	if err != nil {
+		err = fmt.Errorf("bad address %q", a)
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package emulator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSymbolFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string]uint32
+		wantErr string
+	}{
+		{
+			name: "WLA",
+			file: "; wla symbolic information file\n[information]\nversion 2\n\n[labels]\n00:8000 Reset\n80:83d1 NMI_ReadJoypads\n02:c577 EntranceData_room\n00:8010 :+\n\n[definitions]\n00000010 SIZE\n",
+			want: map[string]uint32{"Reset": 0x00_8000, "NMI_ReadJoypads": 0x00_83D1, "EntranceData_room": 0x02_C577},
+		},
+		{
+			name: "asar",
+			file: "[labels]\n00:8000 Reset\n01:873A Underworld_LoadRoom\n00:8020 -\n[source files]\n0000 abcdef main.asm\n",
+			want: map[string]uint32{"Reset": 0x00_8000, "Underworld_LoadRoom": 0x01_873A},
+		},
+		{
+			name: "bass",
+			file: "008000 Reset\n0283a0 Module06_UnderworldLoad\n",
+			want: map[string]uint32{"Reset": 0x00_8000, "Module06_UnderworldLoad": 0x02_83A0},
+		},
+		{
+			name: "no$sns",
+			file: "# no$sns\n00008000 Reset\n008083D1 NMI_ReadJoypads\n",
+			want: map[string]uint32{"Reset": 0x00_8000, "NMI_ReadJoypads": 0x00_83D1},
+		},
+		{name: "missing name", file: "[labels]\n00:8000\n", wantErr: ":2: expected address and name"},
+		{name: "bad address", file: "00:zz00 Reset\n", wantErr: `:1: bad address "00:zz00"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rom.sym")
+			if err := ioutil.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := LoadSymbolFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Len() != len(tt.want) {
+				t.Errorf("got %d symbols; want %d", s.Len(), len(tt.want))
+			}
+			for name, want := range tt.want {
+				if got, ok := s.Addr(name); !ok || got != want {
+					t.Errorf("%s = $%06x, %v; want $%06x", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSymbolsFormat(t *testing.T) {
+	s := NewSymbols()
+	s.Add(0x00_8000, "Reset")
+	s.Add(0x80_83D1, "NMI_ReadJoypads")
+
+	tests := []struct {
+		addr uint32
+		want string
+	}{
+		{0x00_8000, "Reset"},
+		{0x80_8004, "Reset+$4"},
+		{0x00_83D1, "NMI_ReadJoypads"},
+		{0x00_7FFF, ""},
+		{0x01_8000, ""},
+	}
+	for _, tt := range tests {
+		if got := s.Format(tt.addr); got != tt.want {
+			t.Errorf("Format($%06x) = %q; want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// Package emulator is a CPU-only SNES system: a 65816 core with LoROM, WRAM, SRAM and VRAM mapped in and just
+// enough of the memory-mapped IO registers (DMA, VRAM ports) to run game code that loads and draws screens.
+package emulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/alttpo/snes/emulator/cpualt"
+	"io"
+)
+
+type WRAMArray = [0x20000]byte
+type SRAMArray = [0x10000]byte
+type VRAMArray = [0x10000]byte
+type CGRAMArray = [0x200]byte
+type OAMArray = [0x220]byte
+
+type System struct {
+	// emulated system:
+	cpualt.CPU
+	HWIO
+
+	ROM  []byte
+	WRAM *WRAMArray
+	SRAM *SRAMArray
+
+	VRAM  *VRAMArray
+	CGRAM *CGRAMArray // 256 BGR15 colors, little-endian
+	OAM   *OAMArray   // 128 4-byte sprite entries followed by the 32-byte high table
+
+	Logger    io.Writer
+	LoggerCPU io.Writer
+	Tracer    *Tracer
+	Symbols   *Symbols // names addresses in errors and traces
+
+	CycleBudget uint64 // cycles Exec allows before giving up; 0 = DefaultCycleBudget
+	HistoryLen  int    // instructions kept for ExecError; 0 = DefaultHistoryLen, < 0 = none
+
+	calls       []CallFrame // calls not yet returned from since RunUntil started
+	history     []CPURegs   // ring buffer of the registers before each instruction
+	historyNext int
+	historyLen  int
+
+	watchpoints []*Watchpoint
+	accessPC    uint32 // address of the instruction executing, for watchpoints
+	peeking     bool   // reads are not the emulated program's and are not reported to watchpoints
+}
+
+func (s *System) InitMemory() {
+	// allocate memory space if not already assigned:
+	if s.WRAM == nil {
+		s.WRAM = &WRAMArray{}
+	}
+	if s.SRAM == nil {
+		s.SRAM = &SRAMArray{}
+	}
+	if s.VRAM == nil {
+		s.VRAM = &VRAMArray{}
+	}
+	if s.CGRAM == nil {
+		s.CGRAM = &CGRAMArray{}
+	}
+	if s.OAM == nil {
+		s.OAM = &OAMArray{}
+	}
+}
+
+func (s *System) InitEmulatorFrom(initEmu *System) (err error) {
+	s.InitMemory()
+
+	s.Logger = initEmu.Logger
+	s.LoggerCPU = initEmu.LoggerCPU
+	s.Tracer = initEmu.Tracer
+	s.Symbols = initEmu.Symbols
+	s.CycleBudget = initEmu.CycleBudget
+	s.HistoryLen = initEmu.HistoryLen
+	s.watchpoints = initEmu.watchpoints
+
+	s.ROM = initEmu.ROM
+
+	// copy memory contents:
+	*s.WRAM = *initEmu.WRAM
+	*s.SRAM = *initEmu.SRAM
+	*s.VRAM = *initEmu.VRAM
+	*s.CGRAM = *initEmu.CGRAM
+	*s.OAM = *initEmu.OAM
+
+	s.HWIO = initEmu.HWIO
+
+	s.CPU.InitFrom(&initEmu.CPU)
+
+	s.InitLoROMBus()
+
+	s.InitWRAMBus()
+
+	return
+}
+
+func (s *System) InitEmulator() (err error) {
+	s.InitMemory()
+
+	// systems initialized from this one share its count of unimplemented register reads:
+	if s.HWIO.unimplemented == nil {
+		s.HWIO.unimplemented = &unimplementedReadCounts{}
+	}
+
+	// create CPU and Bus:
+	s.CPU.Init()
+
+	s.InitLoROMBus()
+
+	s.InitWRAMBus()
+
+	return
+}
+
+func (s *System) InitLoROMBus() {
+	// map in ROM to Bus; parts of this mapping will be overwritten:
+	for b := uint32(0); b < 0x40; b++ {
+		halfBank := b << 15
+		bank := b << 16
+		s.Bus.AttachReader(
+			bank|0x8000,
+			bank|0xFFFF,
+			func(addr uint32) uint8 { return s.ROM[halfBank+(addr-(bank|0x8000))] },
+		)
+
+		// mirror:
+		s.Bus.AttachReader(
+			(bank+0x80_0000)|0x8000,
+			(bank+0x80_0000)|0xFFFF,
+			func(addr uint32) uint8 { return s.ROM[halfBank+(addr-((bank+0x80_0000)|0x8000))] },
+		)
+	}
+
+	// SRAM (banks 70-7D,F0-FF) (7E,7F) will be overwritten with WRAM:
+	for b := uint32(0); b < uint32(len(s.SRAM)>>15); b++ {
+		bank := b << 16
+		halfBank := b << 15
+		s.Bus.AttachReader(
+			bank+0x70_0000,
+			bank+0x70_7FFF,
+			func(addr uint32) uint8 { return s.readSRAM(halfBank + (addr - (bank + 0x70_0000))) },
+		)
+		s.Bus.AttachReader(
+			bank+0xF0_0000,
+			bank+0xF0_7FFF,
+			func(addr uint32) uint8 { return s.readSRAM(halfBank + (addr - (bank + 0xF0_0000))) },
+		)
+		s.Bus.AttachWriter(
+			bank+0x70_0000,
+			bank+0x70_7FFF,
+			func(addr uint32, val uint8) { s.writeSRAM(halfBank+(addr-(bank+0x70_0000)), val) },
+		)
+		s.Bus.AttachWriter(
+			bank+0xF0_0000,
+			bank+0xF0_7FFF,
+			func(addr uint32, val uint8) { s.writeSRAM(halfBank+(addr-(bank+0xF0_0000)), val) },
+		)
+	}
+}
+
+func (s *System) InitWRAMBus() {
+	// WRAM:
+	{
+		s.Bus.AttachReader(
+			0x7E_0000,
+			0x7F_FFFF,
+			func(addr uint32) uint8 { return s.readWRAM(addr - 0x7E_0000) },
+		)
+		s.Bus.AttachWriter(
+			0x7E_0000,
+			0x7F_FFFF,
+			func(addr uint32, val uint8) { s.writeWRAM(addr-0x7E_0000, val) },
+		)
+
+		// map in first $2000 of each bank 00-3f and 80-bf as a mirror of WRAM:
+		for b := uint32(0); b < 0x40; b++ {
+			bank := b << 16
+			s.Bus.AttachReader(
+				bank,
+				bank|0x1FFF,
+				func(addr uint32) uint8 { return s.readWRAM(addr - bank) },
+			)
+			s.Bus.AttachWriter(
+				bank,
+				bank|0x1FFF,
+				func(addr uint32, val uint8) { s.writeWRAM(addr-bank, val) },
+			)
+		}
+		for b := uint32(0x80); b < 0xC0; b++ {
+			bank := b << 16
+			s.Bus.AttachReader(
+				bank,
+				bank|0x1FFF,
+				func(addr uint32) uint8 { return s.readWRAM(addr - bank) },
+			)
+			s.Bus.AttachWriter(
+				bank,
+				bank|0x1FFF,
+				func(addr uint32, val uint8) { s.writeWRAM(addr-bank, val) },
+			)
+		}
+	}
+
+	// Memory-mapped IO registers:
+	{
+		s.HWIO.s = s
+		for b := uint32(0); b < 0x40; b++ {
+			bank := b << 16
+			s.Bus.AttachReader(
+				bank|0x2000,
+				bank|0x7FFF,
+				s.HWIO.Read,
+			)
+			s.Bus.AttachWriter(
+				bank|0x2000,
+				bank|0x7FFF,
+				s.HWIO.Write,
+			)
+
+			bank = (b + 0x80) << 16
+			s.Bus.AttachReader(
+				bank|0x2000,
+				bank|0x7FFF,
+				s.HWIO.Read,
+			)
+			s.Bus.AttachWriter(
+				bank|0x2000,
+				bank|0x7FFF,
+				s.HWIO.Write,
+			)
+		}
+	}
+
+	return
+}
+
+func (s *System) ReadWRAM24(offs uint32) uint32 {
+	lohi := uint32(binary.LittleEndian.Uint16(s.WRAM[offs : offs+2]))
+	bank := uint32(s.WRAM[offs+3])
+	return bank<<16 | lohi
+}
+
+func (s *System) ReadWRAM16(offs uint32) uint16 {
+	return binary.LittleEndian.Uint16(s.WRAM[offs : offs+2])
+}
+
+func (s *System) ReadWRAM8(offs uint32) uint8 {
+	return s.WRAM[offs]
+}
+
+func (s *System) SetPC(pc uint32) {
+	s.CPU.RK = byte(pc >> 16)
+	s.CPU.PC = uint16(pc & 0xFFFF)
+}
+
+func (s *System) GetPC() uint32 {
+	return uint32(s.CPU.RK)<<16 | uint32(s.CPU.PC)
+}
+
+// RunUntil runs until the PC reaches targetPC or, when targetPC is 0, until an STP. Running into an STP, a
+// WDM with no OnWDM handler or a BRK first, or running for maxCycles, returns an *ExecError:
+func (s *System) RunUntil(targetPC uint32, maxCycles uint64) (cycles uint64, err error) {
+	// clear stopped flag:
+	s.CPU.Stopped = false
+	s.calls = s.calls[:0]
+	s.historyLen = 0
+
+	for cycles = uint64(0); cycles < maxCycles; {
+		if s.LoggerCPU != nil {
+			s.CPU.DisassembleCurrentPC(s.LoggerCPU)
+			fmt.Fprintln(s.LoggerCPU)
+		}
+		pc := s.GetPC()
+		if pc == targetPC {
+			return
+		}
+
+		opcode := s.peek(pc)
+		switch {
+		case opcode == 0x00:
+			err = s.execError(ExecBRK, targetPC, cycles)
+			return
+		case opcode == 0x42 && s.CPU.OnWDM == nil:
+			err = s.execError(ExecWDM, targetPC, cycles)
+			return
+		}
+
+		if s.Tracer != nil {
+			s.peeking = true
+			s.Tracer.trace(s)
+			s.peeking = false
+		}
+		s.recordHistory()
+
+		s.accessPC = pc
+		sp := s.CPU.SP
+		nCycles, abort := s.CPU.Step()
+		cycles += uint64(nCycles)
+
+		s.trackCalls(opcode, pc, sp)
+
+		if abort {
+			// the CPU only aborts on STP:
+			if targetPC != 0 {
+				s.SetPC(pc)
+				err = s.execError(ExecSTP, targetPC, cycles)
+			}
+			return
+		}
+	}
+
+	err = s.execError(ExecTimeout, targetPC, cycles)
+	return
+}
+
+// RunFrames latches each frame of the joypad script in turn and runs the routine at startPC for it; the routine
+// must end in STP. onFrame is called after each frame and returns false to stop early:
+func (s *System) RunFrames(startPC uint32, onFrame func(frame int) bool) (err error) {
+	for frame := 0; s.Joypad.NextFrame(); frame++ {
+		if err = s.ExecAt(startPC, 0); err != nil {
+			return
+		}
+		if onFrame != nil && !onFrame(frame) {
+			return
+		}
+	}
+	return
+}
+
+func (s *System) ExecAt(startPC, donePC uint32) (err error) {
+	s.SetPC(startPC)
+	return s.Exec(donePC)
+}
+
+// Exec runs until the PC reaches donePC, or until an STP when donePC is 0, within CycleBudget cycles:
+func (s *System) Exec(donePC uint32) (err error) {
+	budget := s.CycleBudget
+	if budget == 0 {
+		budget = DefaultCycleBudget
+	}
+
+	_, err = s.RunUntil(donePC, budget)
+	return
+}
+
+// AddrName formats a bus address with its symbol, if any, e.g. "$01c2fd (Underworld_HandleRoomTags)":
+func (s *System) AddrName(addr uint32) string {
+	return addrName(s.Symbols, addr)
+}
@@ -0,0 +1,152 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AddrRange is an inclusive range of 24-bit bus addresses:
+type AddrRange struct {
+	Lo, Hi uint32
+}
+
+func (r AddrRange) Contains(addr uint32) bool { return r.Lo <= addr && addr <= r.Hi }
+
+// ParseAddrRanges parses a comma separated list of address ranges such as "01:8000-01:FFFF", single
+// addresses such as "02:8157", and whole banks such as "01":
+func ParseAddrRanges(s string) (ranges []AddrRange, err error) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		var r AddrRange
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			if r.Lo, err = ParseAddr(part[:i]); err != nil {
+				return
+			}
+			if r.Hi, err = ParseAddr(part[i+1:]); err != nil {
+				return
+			}
+		} else if len(strings.TrimPrefix(part, "$")) <= 2 {
+			var bank uint64
+			if bank, err = strconv.ParseUint(strings.TrimPrefix(part, "$"), 16, 8); err != nil {
+				err = fmt.Errorf("bad bank %q", part)
+				return
+			}
+			r = AddrRange{uint32(bank) << 16, uint32(bank)<<16 | 0xFFFF}
+		} else {
+			if r.Lo, err = ParseAddr(part); err != nil {
+				return
+			}
+			r.Hi = r.Lo
+		}
+
+		ranges = append(ranges, r)
+	}
+	return
+}
+
+// Tracer writes a line for every instruction executed by RunUntil that passes its filters, annotated with the
+// symbols of the instruction's address and of JSR/JSL/JMP/JML targets from Symbols or else the system's own.
+// Systems initialized from one with a Tracer share it; lines are written whole but lines of systems running in
+// parallel interleave, so Prefix can tell them apart:
+type Tracer struct {
+	Symbols *Symbols
+
+	// filters; the zero values trace everything:
+	Ranges   []AddrRange          // trace only instructions in these ranges
+	MaxDepth int                  // trace only instructions fewer than this many calls below where execution started
+	When     func(s *System) bool // trace only while this returns true
+
+	Prefix func(s *System) string // written at the start of each line
+
+	mu  sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+func (t *Tracer) traces(s *System, pc uint32) bool {
+	if t.MaxDepth > 0 && len(s.calls) >= t.MaxDepth {
+		return false
+	}
+	if len(t.Ranges) > 0 {
+		in := false
+		for _, r := range t.Ranges {
+			if r.Contains(pc) || r.Contains(symbolAddr(pc)) {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	if t.When != nil && !t.When(s) {
+		return false
+	}
+	return true
+}
+
+// trace writes the line for the instruction about to be executed:
+func (t *Tracer) trace(s *System) {
+	pc := s.GetPC()
+	if !t.traces(s, pc) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buf
+	b.Reset()
+	if t.Prefix != nil {
+		b.WriteString(t.Prefix(s))
+	}
+	fmt.Fprintf(b, "%2d ", len(s.calls))
+
+	// drop the disassembler's leading cycle count of the previous instruction:
+	start := b.Len()
+	s.CPU.DisassembleCurrentPC(b)
+	if i := bytes.IndexByte(b.Bytes()[start:], '\t'); i >= 0 {
+		tail := append([]byte(nil), b.Bytes()[start+i+1:]...)
+		b.Truncate(start)
+		b.Write(tail)
+	}
+
+	syms := t.Symbols
+	if syms == nil {
+		syms = s.Symbols
+	}
+	if name := syms.Format(pc); name != "" {
+		fmt.Fprintf(b, " ; %s", name)
+	}
+	if target, ok := s.jumpTarget(pc); ok {
+		if name := syms.Format(target); name != "" {
+			fmt.Fprintf(b, " -> %s", name)
+		}
+	}
+	b.WriteByte('\n')
+
+	_, _ = t.w.Write(b.Bytes())
+}
+
+// jumpTarget returns the address a JSR, JSL, JMP or JML with an absolute operand at pc goes to:
+func (s *System) jumpTarget(pc uint32) (target uint32, ok bool) {
+	bank := pc & 0xFF_0000
+	operand := func(i uint32) uint32 { return uint32(s.Bus.EaRead(bank | (pc+i)&0xFFFF)) }
+
+	switch s.Bus.EaRead(pc) {
+	case 0x20, 0x4C: // JSR abs, JMP abs
+		return bank | operand(2)<<8 | operand(1), true
+	case 0x22, 0x5C: // JSL long, JML long
+		return operand(3)<<16 | operand(2)<<8 | operand(1), true
+	}
+	return
+}
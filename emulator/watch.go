@@ -0,0 +1,237 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MemSpace names a memory a Watchpoint covers:
+type MemSpace int
+
+const (
+	SpaceWRAM MemSpace = iota // offsets $00000-$1FFFF; bus $7E0000-$7FFFFF and the low $2000 mirrors
+	SpaceVRAM                 // byte offsets $0000-$FFFF, written through $2118/$2119 and read through $2139/$213A
+	SpaceSRAM                 // offsets into SRAM; bus $700000 onwards
+)
+
+func (m MemSpace) String() string {
+	switch m {
+	case SpaceWRAM:
+		return "wram"
+	case SpaceVRAM:
+		return "vram"
+	case SpaceSRAM:
+		return "sram"
+	}
+	return fmt.Sprintf("MemSpace(%d)", int(m))
+}
+
+// Access selects the reads, writes or both that a Watchpoint reports:
+type Access byte
+
+const (
+	AccessRead Access = 1 << iota
+	AccessWrite
+	AccessReadWrite = AccessRead | AccessWrite
+)
+
+// Watchpoint calls OnAccess for every read or write of its Space between Lo and Hi inclusive, by the CPU, DMA or
+// HDMA. Systems initialized from one with watchpoints share them, so OnAccess must be safe to call from systems
+// running in parallel:
+type Watchpoint struct {
+	Space   MemSpace
+	Lo, Hi  uint32
+	Access  Access
+	Changes bool // report only writes that change the value
+
+	OnAccess func(s *System, hit WatchHit)
+}
+
+// WatchHit describes one access to a watched address:
+type WatchHit struct {
+	Space MemSpace
+	Addr  uint32 // offset into Space
+	Write bool
+	Value uint8  // value read or written
+	Old   uint8  // value before a write
+	PC    uint32 // address of the instruction making the access
+}
+
+func (h WatchHit) String() string {
+	if h.Write {
+		return fmt.Sprintf("%s[$%05x] $%02x -> $%02x", h.Space, h.Addr, h.Old, h.Value)
+	}
+	return fmt.Sprintf("%s[$%05x] == $%02x", h.Space, h.Addr, h.Value)
+}
+
+// AddWatchpoint adds w to the system's watchpoints and returns it for RemoveWatchpoint:
+func (s *System) AddWatchpoint(w Watchpoint) *Watchpoint {
+	p := &w
+	// copy on write so systems sharing the slice are unaffected:
+	s.watchpoints = append(append([]*Watchpoint(nil), s.watchpoints...), p)
+	return p
+}
+
+// RemoveWatchpoint removes a watchpoint returned by AddWatchpoint:
+func (s *System) RemoveWatchpoint(p *Watchpoint) {
+	var ws []*Watchpoint
+	for _, w := range s.watchpoints {
+		if w != p {
+			ws = append(ws, w)
+		}
+	}
+	s.watchpoints = ws
+}
+
+// watch reports an access to the watchpoints covering it:
+func (s *System) watch(space MemSpace, addr uint32, write bool, value, old uint8) {
+	if s.peeking {
+		return
+	}
+	access := AccessRead
+	if write {
+		access = AccessWrite
+	}
+	for _, w := range s.watchpoints {
+		if w.Space != space || addr < w.Lo || addr > w.Hi || w.Access&access == 0 {
+			continue
+		}
+		if write && w.Changes && value == old {
+			continue
+		}
+		w.OnAccess(s, WatchHit{Space: space, Addr: addr, Write: write, Value: value, Old: old, PC: s.accessPC})
+	}
+}
+
+// peek reads the bus without reporting to watchpoints, for disassembly and the like:
+func (s *System) peek(addr uint32) uint8 {
+	s.peeking = true
+	v := s.Bus.EaRead(addr)
+	s.peeking = false
+	return v
+}
+
+func (s *System) readWRAM(offs uint32) uint8 {
+	v := s.WRAM[offs]
+	if s.watchpoints != nil {
+		s.watch(SpaceWRAM, offs, false, v, v)
+	}
+	return v
+}
+
+func (s *System) writeWRAM(offs uint32, val uint8) {
+	if s.watchpoints != nil {
+		s.watch(SpaceWRAM, offs, true, val, s.WRAM[offs])
+	}
+	s.WRAM[offs] = val
+}
+
+func (s *System) readSRAM(offs uint32) uint8 {
+	v := s.SRAM[offs]
+	if s.watchpoints != nil {
+		s.watch(SpaceSRAM, offs, false, v, v)
+	}
+	return v
+}
+
+func (s *System) writeSRAM(offs uint32, val uint8) {
+	if s.watchpoints != nil {
+		s.watch(SpaceSRAM, offs, true, val, s.SRAM[offs])
+	}
+	s.SRAM[offs] = val
+}
+
+func (s *System) readVRAM(offs uint16) uint8 {
+	v := s.VRAM[offs]
+	if s.watchpoints != nil {
+		s.watch(SpaceVRAM, uint32(offs), false, v, v)
+	}
+	return v
+}
+
+func (s *System) writeVRAM(offs uint16, val uint8) {
+	if s.watchpoints != nil {
+		s.watch(SpaceVRAM, uint32(offs), true, val, s.VRAM[offs])
+	}
+	s.VRAM[offs] = val
+}
+
+// ParseWatchpoint parses a watchpoint written as an optional access, "r", "w" or "rw" (default "w"), an
+// optional memory, "wram", "vram" or "sram" (default "wram"), and an address or range, separated by colons:
+// e.g. "7E:0414", "r:7E:2000-7E:3FFF", "rw:vram:4000-4FFF". WRAM and SRAM are given by bus address, VRAM by byte
+// address:
+func ParseWatchpoint(spec string) (w Watchpoint, err error) {
+	w.Space, w.Access = SpaceWRAM, AccessWrite
+
+	rest := spec
+	for {
+		i := strings.IndexByte(rest, ':')
+		if i < 0 {
+			break
+		}
+		switch strings.ToLower(rest[:i]) {
+		case "r":
+			w.Access = AccessRead
+		case "w":
+			w.Access = AccessWrite
+		case "rw":
+			w.Access = AccessReadWrite
+		case "wram":
+			w.Space = SpaceWRAM
+		case "vram":
+			w.Space = SpaceVRAM
+		case "sram":
+			w.Space = SpaceSRAM
+		default:
+			i = -1
+		}
+		if i < 0 {
+			break
+		}
+		rest = rest[i+1:]
+	}
+
+	var ranges []AddrRange
+	if ranges, err = ParseAddrRanges(rest); err != nil {
+		return
+	}
+	if len(ranges) != 1 {
+		err = fmt.Errorf("watchpoint %q: expected one address range", spec)
+		return
+	}
+	lo, hi := ranges[0].Lo, ranges[0].Hi
+	if w.Lo, err = w.Space.offset(lo); err == nil {
+		w.Hi, err = w.Space.offset(hi)
+	}
+	if err == nil && w.Hi < w.Lo {
+		err = fmt.Errorf("range is reversed")
+	}
+	if err != nil {
+		err = fmt.Errorf("watchpoint %q: %w", spec, err)
+	}
+	return
+}
+
+// offset maps a bus address, or a byte address for VRAM, to an offset into the memory:
+func (m MemSpace) offset(addr uint32) (offs uint32, err error) {
+	bank, lo := addr>>16, addr&0xFFFF
+	switch m {
+	case SpaceWRAM:
+		switch {
+		case bank == 0x7E || bank == 0x7F:
+			return addr - 0x7E_0000, nil
+		case bank&0x7F < 0x40 && lo < 0x2000:
+			return lo, nil
+		}
+	case SpaceVRAM:
+		if addr < 0x1_0000 {
+			return addr, nil
+		}
+	case SpaceSRAM:
+		if bank&0x7F >= 0x70 && bank&0x7F < 0x7E && lo < 0x8000 {
+			return (bank&0x7F-0x70)<<15 | lo, nil
+		}
+	}
+	err = fmt.Errorf("$%06x is not in %s", addr, m)
+	return
+}
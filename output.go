@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var (
+	outputDir      string
+	outputNaming   string
+	outputTemplate *template.Template
+)
+
+// outputName is the data passed to the -name file naming template:
+type outputName struct {
+	ROM     string // ROM file name without directory and extension
+	Version string // detected ROM version, e.g. "JP 1.0"
+	Name    string // artifact name, e.g. "eg1", "03f", "03F.bg1.0"
+	Ext     string // file extension without the dot, e.g. "png"
+}
+
+func parseOutputTemplate() (err error) {
+	if outputTemplate, err = template.New("name").Option("missingkey=error").Parse(outputNaming); err != nil {
+		err = fmt.Errorf("output: bad -name template: %w", err)
+		return
+	}
+
+	// validate the template up front so artifact writers need not handle template errors:
+	sb := strings.Builder{}
+	if err = outputTemplate.Execute(&sb, outputName{Name: "eg1", Ext: "png"}); err != nil {
+		err = fmt.Errorf("output: bad -name template: %w", err)
+		return
+	}
+	return
+}
+
+// outputPath renders the naming template for an artifact and places it under the output directory:
+func outputPath(name, ext string) string {
+	rom := filepath.Base(romPath)
+	rom = strings.TrimSuffix(rom, filepath.Ext(rom))
+
+	sb := strings.Builder{}
+	if err := outputTemplate.Execute(&sb, outputName{
+		ROM:     rom,
+		Version: romVersion.String(),
+		Name:    name,
+		Ext:     ext,
+	}); err != nil {
+		panic(err)
+	}
+
+	return filepath.Join(outputDir, filepath.FromSlash(sb.String()))
+}
@@ -0,0 +1,201 @@
+package render
+
+import (
+	"github.com/alttpo/mapgen/emulator"
+	"image"
+	"image/color"
+)
+
+// BG mode 1 priority order from front to back, ignoring BG3 and sprites: BG1.1, BG2.1, BG1.0, BG2.0
+var layerBits = [4]byte{emulator.LayerBG1, emulator.LayerBG2, emulator.LayerBG1, emulator.LayerBG2}
+
+// compositor picks the main and sub screen pixels from the BG1 and BG2 priority layers and blends them the way
+// the PPU's screen designation, window and color math registers say to:
+type compositor struct {
+	pal      color.Palette
+	bg1, bg2 [2]*image.Paletted
+	gamma    bool // convert the fixed color through the gamma ramp like the palette
+}
+
+// screenRegs stands in for registers whose main screen was never set up; both BGs are shown without color
+// math rather than only the backdrop:
+func screenRegs(regs *emulator.PPURegs) *emulator.PPURegs {
+	if regs.TM&(emulator.LayerBG1|emulator.LayerBG2) == 0 {
+		return &emulator.PPURegs{TM: emulator.LayerBG1 | emulator.LayerBG2}
+	}
+	return regs
+}
+
+// sample returns the pixels of each layer in priority order at the given BG1 and BG2 coordinates:
+func (c *compositor) sample(x1, y1, x2, y2 int) [4]uint8 {
+	return [4]uint8{
+		c.bg1[1].ColorIndexAt(x1, y1),
+		c.bg2[1].ColorIndexAt(x2, y2),
+		c.bg1[0].ColorIndexAt(x1, y1),
+		c.bg2[0].ColorIndexAt(x2, y2),
+	}
+}
+
+// pick returns the frontmost opaque pixel of the layers enabled on a screen, or else the backdrop:
+func pick(px [4]uint8, screen byte) (i uint8, layer byte) {
+	for n, bit := range layerBits {
+		if screen&bit != 0 && px[n] != 0 {
+			return px[n], bit
+		}
+	}
+	return 0, emulator.LayerBackdrop
+}
+
+// at returns the palette index of the main screen pixel and, if color math or clipping applies to it, the
+// resulting color; sx is the pixel's screen column for windowing or -1 if it is not on screen:
+func (c *compositor) at(regs *emulator.PPURegs, px [4]uint8, sx int) (i uint8, blended color.Color) {
+	main, sub := regs.TM, regs.TS
+	if sx >= 0 {
+		main &^= regs.WindowMask(regs.TMW&main, sx)
+		sub &^= regs.WindowMask(regs.TSW&sub, sx)
+	}
+
+	var layer byte
+	i, layer = pick(px, main)
+	clip := regs.ClipToBlackAt(sx)
+	math := regs.ColorMathAt(layer, sx)
+	if !clip && !math {
+		return
+	}
+
+	var r1, g1, b1 uint32
+	if !clip {
+		r1, g1, b1, _ = c.pal[i].RGBA()
+	}
+	if !math {
+		blended = color.RGBA64{A: 0xffff}
+		return
+	}
+
+	half := regs.Half()
+	operand := BGR15ToColor(regs.COLDATA, c.gamma)
+	if regs.AddSubscreen() {
+		if j, subLayer := pick(px, sub); subLayer != emulator.LayerBackdrop {
+			operand = c.pal[j]
+		} else {
+			// the sub screen backdrop is the fixed color and is never halved:
+			half = false
+		}
+	}
+
+	r2, g2, b2, _ := operand.RGBA()
+	subtract := regs.Subtract()
+	blended = color.RGBA64{
+		R: mix(r1, r2, subtract, half),
+		G: mix(g1, g2, subtract, half),
+		B: mix(b1, b2, subtract, half),
+		A: 0xffff,
+	}
+	return
+}
+
+func mix(a, b uint32, subtract, half bool) uint16 {
+	if subtract {
+		if b > a {
+			a = 0
+		} else {
+			a -= b
+		}
+	} else {
+		a += b
+	}
+	if half {
+		a >>= 1
+	}
+	return Sat(a)
+}
+
+// compose calls set for every pixel of the 512x512 BG layers. When lines holds per-scanline registers the part
+// of the layers on screen is composed again with each line's registers so that HDMA driven scrolling, windows
+// and color math show up:
+func (c *compositor) compose(regs *emulator.PPURegs, lines []emulator.PPURegs, set func(x, y int, i uint8, blended color.Color)) {
+	regs = screenRegs(regs)
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			i, blended := c.at(regs, c.sample(x, y, x, y), -1)
+			set(x, y, i, blended)
+		}
+	}
+
+	if lines == nil {
+		return
+	}
+
+	// the screen sits where the starting registers scroll the first BG on the main screen:
+	n := 0
+	if regs.TM&emulator.LayerBG1 == 0 {
+		n = 1
+	}
+	ox, oy := int(regs.BGHOFS[n]), int(regs.BGVOFS[n])
+
+	for sy := range lines {
+		r := screenRegs(&lines[sy])
+		for sx := 0; sx < 256; sx++ {
+			px := c.sample(
+				(sx+int(r.BGHOFS[0]))&511,
+				(sy+int(r.BGVOFS[0]))&511,
+				(sx+int(r.BGHOFS[1]))&511,
+				(sy+int(r.BGVOFS[1]))&511,
+			)
+			i, blended := c.at(r, px, sx)
+			set((ox+sx)&511, (oy+sy)&511, i, blended)
+		}
+	}
+}
+
+// ComposeBG composes the BG1 and BG2 priority layers into a true color image using the PPU's main and sub
+// screen layers and color math registers, with lines optionally holding the registers of each scanline. gamma
+// is passed to BGR15ToColor for the fixed color and should match the one pal was made with:
+func ComposeBG(pal color.Palette, gamma bool, bg1, bg2 [2]*image.Paletted, regs *emulator.PPURegs, lines []emulator.PPURegs) *image.NRGBA {
+	c := &compositor{pal: pal, bg1: bg1, bg2: bg2, gamma: gamma}
+
+	g := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	c.compose(regs, lines, func(x, y int, i uint8, blended color.Color) {
+		if blended != nil {
+			g.Set(x, y, blended)
+		} else {
+			g.Set(x, y, pal[i])
+		}
+	})
+
+	return g
+}
+
+// ComposeBGPaletted is ComposeBG for GIF frames; blended colors are stored in the second half of the frame's
+// palette, which BG layers do not use, and once that is full the nearest BG color is used instead. Index 255
+// is left unused for DeltaFrame's transparency:
+func ComposeBGPaletted(pal color.Palette, gamma bool, bg1, bg2 [2]*image.Paletted, regs *emulator.PPURegs, lines []emulator.PPURegs) *image.Paletted {
+	c := &compositor{pal: pal, bg1: bg1, bg2: bg2, gamma: gamma}
+
+	framePal := make(color.Palette, 256)
+	copy(framePal, pal)
+	frame := image.NewPaletted(image.Rect(0, 0, 512, 512), framePal)
+
+	hc := 128
+	mixedColors := make(map[color.Color]uint8, 128)
+
+	c.compose(regs, lines, func(x, y int, i uint8, blended color.Color) {
+		if blended != nil {
+			var ok bool
+			if i, ok = mixedColors[blended]; !ok {
+				if hc < len(framePal)-1 {
+					i = uint8(hc)
+					framePal[i] = blended
+					hc++
+				} else {
+					i = uint8(framePal[:128].Index(blended))
+				}
+				mixedColors[blended] = i
+			}
+		}
+
+		frame.SetColorIndex(x, y, i)
+	})
+
+	return frame
+}
@@ -0,0 +1,261 @@
+// Package render draws SNES 4bpp tile graphics and BG layers into images and writes them out as PNGs and GIFs.
+package render
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// CreateParentDir makes sure the directory to contain the file at path exists:
+func CreateParentDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// DeltaFrame returns a GIF frame holding only the pixels of curr whose color differs from prev's; the rest are
+// transparent. The frames may have different palettes but curr must leave index 255 unused:
+func DeltaFrame(prev, curr *image.Paletted) (delta *image.Paletted) {
+	// make a special delta palette with 255 (never used) as transparent:
+	pal := make(color.Palette, 256)
+	copy(pal, curr.Palette)
+
+	transparentIndex := uint8(255)
+	pal[transparentIndex] = color.Transparent
+
+	delta = image.NewPaletted(image.Rect(0, 0, 512, 512), pal)
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			cp := prev.ColorIndexAt(x, y)
+			cc := curr.ColorIndexAt(x, y)
+
+			if sameColor(prev.Palette[cp], curr.Palette[cc]) {
+				// set as transparent since nothing changed:
+				delta.SetColorIndex(x, y, transparentIndex)
+				continue
+			}
+
+			// use the current frame's color if it differs:
+			delta.SetColorIndex(x, y, cc)
+		}
+	}
+
+	return
+}
+
+func sameColor(a, b color.Color) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
+
+// NewBlankFrame returns a fully transparent 512x512 layer:
+func NewBlankFrame() *image.Paletted {
+	return image.NewPaletted(
+		image.Rect(0, 0, 512, 512),
+		color.Palette{color.Transparent},
+	)
+}
+
+// saturate a 16-bit value:
+func Sat(v uint32) uint16 {
+	if v > 0xffff {
+		return 0xffff
+	}
+	return uint16(v)
+}
+
+// prefer p1's color unless it's zero:
+func Pick(c0, c1 uint8) uint8 {
+	if c1 != 0 {
+		return c1
+	} else {
+		return c0
+	}
+}
+
+// RenderGIF writes out an animated GIF, holding its last frame for 3 seconds:
+func RenderGIF(g *gif.GIF, fname string) (err error) {
+	// present last frame for 3 seconds:
+	f := len(g.Delay) - 1
+	if f >= 0 {
+		g.Delay[f] = 300
+	}
+
+	if err = CreateParentDir(fname); err != nil {
+		return
+	}
+
+	// render GIF:
+	var gw *os.File
+	gw, err = os.OpenFile(
+		fname,
+		os.O_TRUNC|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return
+	}
+	defer gw.Close()
+
+	err = gif.EncodeAll(gw, g)
+	return
+}
+
+// ExportPNG writes an image to a PNG file, creating its directory if needed:
+func ExportPNG(name string, g image.Image) (err error) {
+	// export to PNG:
+	var po *os.File
+
+	if err = CreateParentDir(name); err != nil {
+		return
+	}
+
+	po, err = os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err = po.Close()
+		if err != nil {
+			return
+		}
+	}()
+
+	bo := bufio.NewWriterSize(po, 8*1024*1024)
+
+	err = png.Encode(bo, g)
+	if err != nil {
+		return
+	}
+
+	err = bo.Flush()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+var gammaRamp = [...]uint8{
+	0x00, 0x01, 0x03, 0x06, 0x0a, 0x0f, 0x15, 0x1c,
+	0x24, 0x2d, 0x37, 0x42, 0x4e, 0x5b, 0x69, 0x78,
+	0x88, 0x90, 0x98, 0xa0, 0xa8, 0xb0, 0xb8, 0xc0,
+	0xc8, 0xd0, 0xd8, 0xe0, 0xe8, 0xf0, 0xf8, 0xff,
+}
+
+// CGRAMToPalette converts 256 BGR15 colors into a palette, through the bsnes gamma ramp if gamma is set:
+func CGRAMToPalette(cgram []uint16, gamma bool) color.Palette {
+	pal := make(color.Palette, 256)
+	for i, bgr15 := range cgram {
+		pal[i] = BGR15ToColor(bgr15, gamma)
+	}
+	return pal
+}
+
+// BGR15ToColor converts one BGR15 color (MSB unused) to RGB24:
+func BGR15ToColor(bgr15 uint16, gamma bool) color.Color {
+	b := (bgr15 & 0x7C00) >> 10
+	g := (bgr15 & 0x03E0) >> 5
+	r := bgr15 & 0x001F
+	if gamma {
+		return color.NRGBA{
+			R: gammaRamp[r],
+			G: gammaRamp[g],
+			B: gammaRamp[b],
+			A: 0xff,
+		}
+	}
+	return color.NRGBA{
+		R: uint8(r<<3 | r>>2),
+		G: uint8(g<<3 | g>>2),
+		B: uint8(b<<3 | b>>2),
+		A: 0xff,
+	}
+}
+
+// RenderBG draws the 64x64 BG tilemap tiles of the given priority:
+func RenderBG(g *image.Paletted, bg []uint16, tiles []uint8, prio uint8) {
+	a := uint32(0)
+	for ty := 0; ty < 64; ty++ {
+		for tx := 0; tx < 64; tx++ {
+			z := bg[a]
+			a++
+
+			// priority check:
+			if (z&0x2000 != 0) != (prio != 0) {
+				continue
+			}
+
+			Draw4bppTile(g, z, tiles, tx, ty)
+		}
+	}
+}
+
+// RenderBGSep draws a 64x64 BG tilemap into separate priority 0 and 1 layers:
+func RenderBGSep(g [2]*image.Paletted, bg []uint16, tiles []uint8, p0 bool, p1 bool) {
+	a := uint32(0)
+	for ty := 0; ty < 64; ty++ {
+		for tx := 0; tx < 64; tx++ {
+			z := bg[a]
+			a++
+
+			// priority check:
+			p := (z & 0x2000) >> 13
+			if p == 0 && !p0 {
+				continue
+			}
+			if p == 1 && !p1 {
+				continue
+			}
+			Draw4bppTile(g[p], z, tiles, tx, ty)
+		}
+	}
+}
+
+// Draw4bppTile draws one 4bpp tilemap entry z at tile coordinates tx,ty:
+func Draw4bppTile(g *image.Paletted, z uint16, tiles []uint8, tx int, ty int) {
+	//High     Low          Legend->  c: Starting character (tile) number
+	//vhopppcc cccccccc               h: horizontal flip  v: vertical flip
+	//                                p: palette number   o: priority bit
+
+	p := byte((z>>10)&7) << 4
+	c := int(z & 0x03FF)
+	for y := 0; y < 8; y++ {
+		fy := y
+		if z&0x8000 != 0 {
+			fy = 7 - y
+		}
+		p0 := tiles[(c<<5)+(y<<1)]
+		p1 := tiles[(c<<5)+(y<<1)+1]
+		p2 := tiles[(c<<5)+(y<<1)+16]
+		p3 := tiles[(c<<5)+(y<<1)+17]
+		for x := 0; x < 8; x++ {
+			fx := x
+			if z&0x4000 == 0 {
+				fx = 7 - x
+			}
+
+			i := byte((p0>>x)&1) |
+				byte(((p1>>x)&1)<<1) |
+				byte(((p2>>x)&1)<<2) |
+				byte(((p3>>x)&1)<<3)
+
+			// transparency:
+			if i == 0 {
+				continue
+			}
+
+			g.SetColorIndex(tx<<3+fx, ty<<3+fy, p+i)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/alttpo/mapgen/underworld"
+	"sort"
+	"strings"
+)
+
+// hexSet is a flag.Value for comma-separated lists of hex numbers and inclusive ranges, e.g. "00-0f,12":
+type hexSet struct {
+	max  uint64
+	vals map[uint64]bool
+}
+
+func (v *hexSet) String() string {
+	if v == nil || v.vals == nil {
+		return ""
+	}
+	keys := make([]uint64, 0, len(v.vals))
+	for k := range v.vals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%02x", k))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *hexSet) Set(s string) (err error) {
+	if v.vals == nil {
+		v.vals = make(map[uint64]bool)
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var lo, hi uint64
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			if lo, err = parseHex(part[:i], 16); err != nil {
+				return
+			}
+			if hi, err = parseHex(part[i+1:], 16); err != nil {
+				return
+			}
+		} else {
+			if lo, err = parseHex(part, 16); err != nil {
+				return
+			}
+			hi = lo
+		}
+
+		if lo > hi {
+			return fmt.Errorf("bad range %q", part)
+		}
+		if hi > v.max {
+			return fmt.Errorf("%q exceeds maximum $%x", part, v.max)
+		}
+
+		for n := lo; n <= hi; n++ {
+			v.vals[n] = true
+		}
+	}
+	return
+}
+
+var (
+	entranceSet  = hexSet{max: underworld.EntranceCount - 1}
+	supertileSet = hexSet{max: 0x127}
+)
+
+// addSelectionFlags registers the flags that fill in the selection after parsing:
+func addSelectionFlags(fs *flag.FlagSet, withEntrances bool) {
+	if withEntrances {
+		fs.Var(&entranceSet, "entrances", "only load these entrance IDs, e.g. \"00-08,0c\" (default all)")
+		fs.BoolVar(&cfg.Selection.Reachable, "reachable", false, "also keep the rooms reachable from the selected supertiles")
+	}
+	fs.Var(&supertileSet, "supertiles", "only process these supertiles, e.g. \"050-05f,072\"; rooms are discovered through entrances and other selected rooms (default all)")
+}
+
+// applySelectionFlags converts the parsed flag values into the selection:
+func applySelectionFlags() {
+	if entranceSet.vals != nil {
+		cfg.Selection.Entrances = make(map[uint8]bool, len(entranceSet.vals))
+		for n := range entranceSet.vals {
+			cfg.Selection.Entrances[uint8(n)] = true
+		}
+	}
+	if supertileSet.vals != nil {
+		cfg.Selection.Supertiles = make(map[underworld.Supertile]bool, len(supertileSet.vals))
+		for n := range supertileSet.vals {
+			cfg.Selection.Supertiles[underworld.Supertile(n)] = true
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"os"
+	"strings"
+)
+
+var (
+	tracePath  string
+	tracePCs   string
+	traceDepth int
+	traceRooms string
+
+	traceFile   *os.File
+	traceWriter *bufio.Writer
+)
+
+func addTraceFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tracePath, "trace", "", "write a symbolized trace of executed instructions to this file")
+	fs.StringVar(&tracePCs, "tracepc", "", "trace only these PC ranges or banks; e.g. 01:8000-01:FFFF,02")
+	fs.IntVar(&traceDepth, "tracedepth", 0, "trace only this many calls deep into each harness routine; 0 = no limit")
+	fs.StringVar(&traceRooms, "traceroom", "", "trace only while one of these supertiles is loaded in $A0; e.g. 104,105")
+}
+
+// startTrace attaches a tracer to the initialized system, which all systems cloned from it share. Tracing starts
+// after the game's initialization so the trace covers only the command's work:
+func startTrace(e *emulator.System) (err error) {
+	if tracePath == "" {
+		return
+	}
+
+	var ranges []emulator.AddrRange
+	if tracePCs != "" {
+		if ranges, err = emulator.ParseAddrRanges(tracePCs); err != nil {
+			return fmt.Errorf("-tracepc: %w", err)
+		}
+	}
+
+	var when func(s *emulator.System) bool
+	if traceRooms != "" {
+		rooms := make(map[uint16]bool)
+		for _, s := range strings.Split(traceRooms, ",") {
+			var st uint64
+			if st, err = parseHex(strings.TrimSpace(s), 16); err != nil {
+				return fmt.Errorf("-traceroom: %w", err)
+			}
+			rooms[uint16(st)] = true
+		}
+		when = func(s *emulator.System) bool { return rooms[s.ReadWRAM16(0xA0)] }
+	}
+
+	if traceFile, err = os.Create(tracePath); err != nil {
+		return
+	}
+	traceWriter = bufio.NewWriterSize(traceFile, 1<<20)
+
+	t := emulator.NewTracer(traceWriter)
+	t.Ranges = ranges
+	t.MaxDepth = traceDepth
+	t.When = when
+	if parallelRooms {
+		// lines of rooms worked on in parallel interleave:
+		t.Prefix = func(s *emulator.System) string { return fmt.Sprintf("%03x ", s.ReadWRAM16(0xA0)) }
+	}
+	e.Tracer = t
+	return
+}
+
+// stopTrace flushes and closes the trace file:
+func stopTrace() (err error) {
+	if traceFile == nil {
+		return
+	}
+	if err = traceWriter.Flush(); err != nil {
+		traceFile.Close()
+		return
+	}
+	return traceFile.Close()
+}
@@ -0,0 +1,218 @@
+package underworld
+
+import (
+	"fmt"
+	"github.com/alttpo/mapgen/render"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"sync"
+)
+
+// RenderAtlas composes the rendered rooms in rows rowStart to rowStart+rowCount of the supertile grid into one PNG:
+func (c *Config) RenderAtlas(fname string, entranceGroups []Entrance, rowStart int, rowCount int) (err error) {
+
+	const divider = 1
+	supertilepx := 512 / divider
+
+	wga := &sync.WaitGroup{}
+	roomsRendered := progress{name: fname + " rooms rendered"}
+
+	all := image.NewNRGBA(image.Rect(0, 0, 0x10*supertilepx, (rowCount*0x10*supertilepx)/0x10))
+	// clear the image and remove alpha layer
+	draw.Draw(
+		all,
+		all.Bounds(),
+		image.NewUniform(color.NRGBA{0, 0, 0, 255}),
+		image.Point{},
+		draw.Src)
+
+	greenTint := image.NewUniform(color.NRGBA{0, 255, 0, 64})
+	redTint := image.NewUniform(color.NRGBA{255, 0, 0, 56})
+	cyanTint := image.NewUniform(color.NRGBA{0, 255, 255, 64})
+	blueTint := image.NewUniform(color.NRGBA{0, 0, 255, 64})
+
+	black := image.NewUniform(color.RGBA{0, 0, 0, 255})
+	yellow := image.NewUniform(color.RGBA{255, 255, 0, 255})
+	white := image.NewUniform(color.RGBA{255, 255, 255, 255})
+
+	for i := range entranceGroups {
+		g := &entranceGroups[i]
+		for _, room := range g.Rooms {
+			st := int(room.Supertile)
+
+			row := st/0x10 - rowStart
+			col := st % 0x10
+			if row < 0 || row >= rowCount {
+				continue
+			}
+
+			room := room
+			roomsRendered.add(1)
+			c.goWork(wga, func() {
+				defer roomsRendered.step()
+
+				fmt.Printf("entrance $%02x supertile %s render start\n", g.EntranceID, room.Supertile)
+
+				stx := col * supertilepx
+				sty := row * supertilepx
+
+				if room.Rendered != nil {
+					draw.Draw(
+						all,
+						image.Rect(stx, sty, stx+supertilepx, sty+supertilepx),
+						room.Rendered,
+						image.Point{},
+						draw.Src,
+					)
+				}
+
+				// highlight tiles that are reachable:
+				if c.DrawOverlays {
+					maxRange := 0x2000
+					if room.IsDarkRoom() {
+						maxRange = 0x1000
+					}
+
+					// draw supertile over pits, bombable floors, and warps:
+					for j := range room.ExitPoints {
+						ep := &room.ExitPoints[j]
+						if !ep.WorthMarking {
+							continue
+						}
+
+						_, er, ec := ep.Point.RowCol()
+						x := int(ec) << 3
+						y := int(er) << 3
+						fd0 := font.Drawer{
+							Dst:  all,
+							Src:  black,
+							Face: inconsolata.Regular8x16,
+							Dot:  fixed.Point26_6{fixed.I(stx + x + 1), fixed.I(sty + y + 1)},
+						}
+						fd1 := font.Drawer{
+							Dst:  all,
+							Src:  yellow,
+							Face: inconsolata.Regular8x16,
+							Dot:  fixed.Point26_6{fixed.I(stx + x), fixed.I(sty + y)},
+						}
+						stStr := fmt.Sprintf("%02X", uint16(ep.Supertile))
+						fd0.DrawString(stStr)
+						fd1.DrawString(stStr)
+					}
+
+					// draw supertile over stairs:
+					for j := range room.Stairs {
+						sn := room.StairExitTo[j]
+						_, er, ec := room.Stairs[j].RowCol()
+
+						x := int(ec) << 3
+						y := int(er) << 3
+						fd0 := font.Drawer{
+							Dst:  all,
+							Src:  black,
+							Face: inconsolata.Regular8x16,
+							Dot:  fixed.Point26_6{fixed.I(stx + 8 + x + 1), fixed.I(sty - 8 + y + 1 + 12)},
+						}
+						fd1 := font.Drawer{
+							Dst:  all,
+							Src:  yellow,
+							Face: inconsolata.Regular8x16,
+							Dot:  fixed.Point26_6{fixed.I(stx + 8 + x), fixed.I(sty - 8 + y + 12)},
+						}
+						stStr := fmt.Sprintf("%02X", uint16(sn))
+						fd0.DrawString(stStr)
+						fd1.DrawString(stStr)
+					}
+
+					for t := 0; t < maxRange; t++ {
+						v := room.Reachable[t]
+						if v == 0x01 {
+							continue
+						}
+
+						tt := MapCoord(t)
+						lyr, tr, tc := tt.RowCol()
+						overlay := greenTint
+						if lyr != 0 {
+							overlay = cyanTint
+						}
+						if v == 0x20 || v == 0x62 {
+							overlay = redTint
+						}
+
+						x := int(tc) << 3
+						y := int(tr) << 3
+						draw.Draw(
+							all,
+							image.Rect(stx+x, sty+y, stx+x+8, sty+y+8),
+							overlay,
+							image.Point{},
+							draw.Over,
+						)
+					}
+
+					for t, d := range room.Hookshot {
+						_, tr, tc := t.RowCol()
+						x := int(tc) << 3
+						y := int(tr) << 3
+
+						overlay := blueTint
+						_ = d
+
+						draw.Draw(
+							all,
+							image.Rect(stx+x, sty+y, stx+x+8, sty+y+8),
+							overlay,
+							image.Point{},
+							draw.Over,
+						)
+					}
+				}
+
+				fmt.Printf("entrance $%02x supertile %s render complete\n", g.EntranceID, room.Supertile)
+			})
+		}
+	}
+	wga.Wait()
+
+	if c.DrawNumbers {
+		// cheap enough to draw on this goroutine:
+		for st := 0; st < 0x128; st++ {
+			row := st/0x10 - rowStart
+			col := st % 0x10
+			if row < 0 || row >= rowCount {
+				continue
+			}
+
+			stx := col * supertilepx
+			sty := row * supertilepx
+
+			// draw supertile number in top-left:
+			var stStr string
+			if st < 0x100 {
+				stStr = fmt.Sprintf("%02X", st)
+			} else {
+				stStr = fmt.Sprintf("%03X", st)
+			}
+			(&font.Drawer{
+				Dst:  all,
+				Src:  black,
+				Face: inconsolata.Bold8x16,
+				Dot:  fixed.Point26_6{fixed.I(stx + 5), fixed.I(sty + 5 + 12)},
+			}).DrawString(stStr)
+			(&font.Drawer{
+				Dst:  all,
+				Src:  white,
+				Face: inconsolata.Bold8x16,
+				Dot:  fixed.Point26_6{fixed.I(stx + 4), fixed.I(sty + 4 + 12)},
+			}).DrawString(stStr)
+		}
+	}
+
+	err = render.ExportPNG(c.OutputPath(fname, "png"), all)
+	return
+}
@@ -1,4 +1,4 @@
-package main
+package underworld
 
 type Door struct {
 	Type DoorType  // $1980
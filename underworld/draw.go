@@ -0,0 +1,228 @@
+package underworld
+
+import (
+	"fmt"
+	"github.com/alttpo/mapgen/render"
+	"image"
+	"image/color"
+	"image/gif"
+	"unsafe"
+)
+
+func (room *RoomState) CaptureRoomDrawFrame() {
+	var tileMap [0x4000]byte
+	copy(tileMap[:], room.WRAM[0x2000:0x6000])
+	room.AnimatedTileMap = append(room.AnimatedTileMap, tileMap)
+	room.AnimatedLayers = append(room.AnimatedLayers, room.AnimatedLayer)
+}
+
+// palette returns the colors to draw the room with, taken from the PPU's CGRAM or else the game's palette
+// buffer in WRAM at $C300:
+func (room *RoomState) palette() color.Palette {
+	c := room.cfg
+	if c.PaletteFromCGRAM {
+		return render.CGRAMToPalette((*(*[0x100]uint16)(unsafe.Pointer(&room.e.CGRAM[0])))[:], c.UseGammaRamp)
+	}
+	return render.CGRAMToPalette((*(*[0x100]uint16)(unsafe.Pointer(&room.WRAM[0xC300])))[:], c.UseGammaRamp)
+}
+
+func (room *RoomState) RenderAnimatedRoomDraw(frameDelay int) {
+	c := room.cfg
+	wram := (&room.WRAM)[:]
+
+	// assume WRAM has rendering state as well:
+	isDark := room.IsDarkRoom()
+	doBG2 := !isDark
+
+	// INIDISP contains PPU brightness
+	brightness := read8(wram, 0x13) & 0xF
+	_ = brightness
+
+	// layer order and color math come from the PPU registers:
+	regs := &room.e.HWIO.PPURegs
+
+	//ioutil.WriteFile(fmt.Sprintf("data/%03X.vram", st), vram, 0644)
+
+	tileset := (&room.VRAMTileSet)[:]
+	var lastFrame *image.Paletted = nil
+
+	for i, tileMap := range room.AnimatedTileMap {
+		bg1wram := (*(*[0x1000]uint16)(unsafe.Pointer(&tileMap[0])))[:]
+		bg2wram := (*(*[0x1000]uint16)(unsafe.Pointer(&tileMap[0x2000])))[:]
+
+		pal := room.palette()
+
+		bg1p := [2]*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+			image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+		}
+		bg2p := [2]*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+			image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+		}
+
+		// render all separate BG1 and BG2 priority layers:
+		layer := room.AnimatedLayers[i]
+		if layer != 2 {
+			render.RenderBGSep(bg1p, bg1wram, tileset, c.DrawBG1p0, c.DrawBG1p1)
+		}
+		if doBG2 {
+			render.RenderBGSep(bg2p, bg2wram, tileset, c.DrawBG2p0, c.DrawBG2p1)
+		}
+
+		frame := render.ComposeBGPaletted(pal, c.UseGammaRamp, bg1p, bg2p, regs, nil)
+
+		delta := frame
+		disposal := byte(0)
+		if lastFrame != nil && c.OptimizeGIFs {
+			delta = render.DeltaFrame(lastFrame, frame)
+			disposal = gif.DisposalNone
+		}
+
+		room.Animated.Image = append(room.Animated.Image, delta)
+		room.Animated.Delay = append(room.Animated.Delay, frameDelay)
+		room.Animated.Disposal = append(room.Animated.Disposal, disposal)
+
+		lastFrame = frame
+	}
+}
+
+func (room *RoomState) DrawSupertile() (err error) {
+	// gfx output is:
+	//  s.VRAM: $4000[0x2000] = 4bpp tile graphics
+	//  s.WRAM: $2000[0x2000] = BG1 64x64 tile map  [64][64]uint16
+	//  s.WRAM: $4000[0x2000] = BG2 64x64 tile map  [64][64]uint16
+	//  s.WRAM:$12000[0x1000] = BG1 64x64 tile type [64][64]uint8
+	//  s.WRAM:$12000[0x1000] = BG2 64x64 tile type [64][64]uint8
+	//  s.WRAM: $C300[0x0200] = CGRAM palette
+
+	c := room.cfg
+	wram := (&room.WRAM)[:]
+
+	// assume WRAM has rendering state as well:
+	isDark := room.IsDarkRoom()
+
+	// INIDISP contains PPU brightness
+	brightness := read8(wram, 0x13) & 0xF
+	_ = brightness
+
+	//ioutil.WriteFile(fmt.Sprintf("data/%03X.vram", st), vram, 0644)
+
+	pal := room.palette()
+
+	// render BG image:
+
+	bg1p := [2]*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+		image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+	}
+	bg2p := [2]*image.Paletted{
+		image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+		image.NewPaletted(image.Rect(0, 0, 512, 512), pal),
+	}
+
+	doBG2 := !isDark
+
+	bg1wram := (*(*[0x1000]uint16)(unsafe.Pointer(&wram[0x2000])))[:]
+	bg2wram := (*(*[0x1000]uint16)(unsafe.Pointer(&wram[0x4000])))[:]
+	tileset := (&room.VRAMTileSet)[:]
+
+	// render all separate BG1 and BG2 priority layers:
+	render.RenderBGSep(bg1p, bg1wram, tileset, c.DrawBG1p0, c.DrawBG1p1)
+	if doBG2 {
+		render.RenderBGSep(bg2p, bg2wram, tileset, c.DrawBG2p0, c.DrawBG2p1)
+	}
+
+	// layer order and color math come from the PPU registers:
+	regs := &room.e.HWIO.PPURegs
+
+	if room.Rendered != nil {
+		// subsequent GIF frames:
+		frame := render.ComposeBGPaletted(pal, c.UseGammaRamp, bg1p, bg2p, regs, room.Scanlines)
+
+		room.GIF.Image = append(room.GIF.Image, frame)
+		room.GIF.Delay = append(room.GIF.Delay, 50)
+		room.GIF.Disposal = append(room.GIF.Disposal, gif.DisposalNone)
+
+		return
+	}
+
+	blankFrame := render.NewBlankFrame()
+	blank := [2]*image.Paletted{blankFrame, blankFrame}
+
+	// first GIF frames build up the layers from back to front:
+	frames := [4]*image.Paletted{
+		render.ComposeBGPaletted(pal, c.UseGammaRamp, blank, [2]*image.Paletted{bg2p[0], blankFrame}, regs, room.Scanlines),
+		render.ComposeBGPaletted(pal, c.UseGammaRamp, [2]*image.Paletted{bg1p[0], blankFrame}, [2]*image.Paletted{bg2p[0], blankFrame}, regs, room.Scanlines),
+		render.ComposeBGPaletted(pal, c.UseGammaRamp, [2]*image.Paletted{bg1p[0], blankFrame}, bg2p, regs, room.Scanlines),
+		render.ComposeBGPaletted(pal, c.UseGammaRamp, bg1p, bg2p, regs, room.Scanlines),
+	}
+
+	room.GIF.Image = append(room.GIF.Image, frames[:]...)
+	room.GIF.Delay = append(room.GIF.Delay, 50, 50, 50, 50)
+	room.GIF.Disposal = append(room.GIF.Disposal, 0, 0, 0, 0)
+
+	g := render.ComposeBG(pal, c.UseGammaRamp, bg1p, bg2p, regs, room.Scanlines)
+
+	//if isDark {
+	//	// darken the room
+	//	draw.Draw(
+	//		g,
+	//		g.Bounds(),
+	//		image.NewUniform(color.RGBA64{0, 0, 0, 0x8000}),
+	//		image.Point{},
+	//		draw.Over,
+	//	)
+	//}
+
+	//if brightness < 15 {
+	//	draw.Draw(
+	//		g,
+	//		g.Bounds(),
+	//		image.NewUniform(color.RGBA64{0, 0, 0, uint16(brightness) << 12}),
+	//		image.Point{},
+	//		draw.Over,
+	//	)
+	//}
+
+	// store full underworld rendering for inclusion into EG map:
+	room.Rendered = g
+
+	if c.DrawBGLayerPNGs {
+		// color 0 is transparent in the separate layers:
+		palTransp := make(color.Palette, len(pal))
+		copy(palTransp, pal)
+		palTransp[0] = color.Transparent
+		room.bgLayers = [4]*image.Paletted{bg1p[0], bg1p[1], bg2p[0], bg2p[1]}
+		for _, l := range room.bgLayers {
+			l.Palette = palTransp
+		}
+	}
+
+	return
+}
+
+// ExportPNGs writes the room's PNGs as drawn when it was loaded, if Config.DrawRoomPNGs or DrawBGLayerPNGs ask
+// for them:
+func (room *RoomState) ExportPNGs() (err error) {
+	c := room.cfg
+	name := fmt.Sprintf("%03X", uint16(room.Supertile))
+
+	if c.DrawRoomPNGs && room.Rendered != nil {
+		if err = render.ExportPNG(c.OutputPath(name, "png"), room.Rendered); err != nil {
+			return
+		}
+	}
+
+	if room.bgLayers[0] != nil {
+		for i, l := range room.bgLayers {
+			if err = render.ExportPNG(c.OutputPath(fmt.Sprintf("%s.bg%d.%d", name, i/2+1, i&1), "png"), l); err != nil {
+				return
+			}
+		}
+		// only needed for the export:
+		room.bgLayers = [4]*image.Paletted{}
+	}
+
+	return
+}
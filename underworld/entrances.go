@@ -0,0 +1,323 @@
+package underworld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/mapgen/render"
+	"image"
+	"sync"
+)
+
+// EntranceCount is the number of underworld entrance IDs:
+const EntranceCount = 0x85
+
+// DiscoverEntrances loads every selected entrance and discovers all rooms reachable from it, passing through
+// supertiles outside the selection to reach the selected ones. Only the selected supertiles, and with
+// Selection.Reachable the rooms reachable from them, are kept in the entrances' Rooms and drawn; entrances
+// which reach none of them are dropped, and it is an error if no entrance does:
+func (c *Config) DiscoverEntrances() (entranceGroups []Entrance, err error) {
+	entranceGroups = make([]Entrance, 0, EntranceCount)
+	for eID := uint8(0); eID < EntranceCount; eID++ {
+		if c.Selection.IncludesEntrance(eID) {
+			entranceGroups = append(entranceGroups, Entrance{EntranceID: eID})
+		}
+	}
+	c.supertiles = make(map[Supertile]*RoomState, 0x128)
+	c.links = make(map[Supertile][]Supertile, 0x128)
+
+	entrancesProgress := progress{name: "entrances", total: int64(len(entranceGroups))}
+
+	// iterate over entrances:
+	wg := sync.WaitGroup{}
+	for i := range entranceGroups {
+		g := &entranceGroups[i]
+
+		// process entrances in parallel
+		c.goWork(&wg, func() {
+			defer entrancesProgress.step()
+			defer func() {
+				// a bug in one entrance must not take down the others:
+				if r := recover(); r != nil {
+					c.recordEntranceFailure(g.EntranceID, fmt.Errorf("panic: %v", r))
+				}
+			}()
+
+			if err := c.processEntrance(g); err != nil {
+				c.recordEntranceFailure(g.EntranceID, err)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if c.Selection.Supertiles != nil {
+		keep := c.keptSupertiles()
+		if len(keep) == 0 {
+			err = fmt.Errorf("underworld: none of the selected supertiles is reached from the selected entrances")
+			return
+		}
+
+		kept := entranceGroups[:0]
+		for _, g := range entranceGroups {
+			rooms := g.Rooms[:0]
+			for _, room := range g.Rooms {
+				if keep[room.Supertile] {
+					rooms = append(rooms, room)
+				}
+			}
+			if g.Rooms = rooms; len(rooms) != 0 {
+				kept = append(kept, g)
+			}
+		}
+		entranceGroups = kept
+		fmt.Printf("kept %d of %d discovered room(s) from %d entrance(s)\n", len(keep), len(c.supertiles), len(entranceGroups))
+	}
+
+	// draw the rooms kept:
+	for i := range entranceGroups {
+		c.drawEntranceRooms(&entranceGroups[i], &wg)
+	}
+
+	wg.Wait()
+
+	return
+}
+
+// keptSupertiles returns the discovered supertiles that are selected or, with Selection.Reachable, reachable
+// from a selected one through the exits found:
+func (c *Config) keptSupertiles() (keep map[Supertile]bool) {
+	keep = make(map[Supertile]bool)
+	queue := make([]Supertile, 0, len(c.supertiles))
+	for st := range c.supertiles {
+		if c.Selection.IncludesSupertile(st) {
+			keep[st] = true
+			queue = append(queue, st)
+		}
+	}
+
+	for c.Selection.Reachable && len(queue) != 0 {
+		st := queue[0]
+		queue = queue[1:]
+		for _, n := range c.links[st] {
+			if _, found := c.supertiles[n]; found && !keep[n] {
+				keep[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	return
+}
+
+// processEntrance loads entrance g and discovers the rooms reachable from it that no other entrance discovered
+// first:
+func (c *Config) processEntrance(g *Entrance) (err error) {
+	e := &emulator.System{}
+	if err = e.InitEmulatorFrom(c.Harness.System); err != nil {
+		return
+	}
+
+	eID := g.EntranceID
+	fmt.Printf("entrance $%02x load start\n", eID)
+
+	if eID > 0 {
+		//e.LoggerCPU = os.Stdout
+	}
+	if err = c.Harness.LoadEntrance(e, eID); err != nil {
+		return
+	}
+	e.LoggerCPU = nil
+
+	fmt.Printf("entrance $%02x load complete\n", eID)
+
+	g.Supertile = Supertile(read16(e.WRAM[:], 0xA0))
+
+	{
+		// if this is the entrance, Link should be already moved to his starting position:
+		wram := e.WRAM[:]
+		linkX := read16(wram, 0x22)
+		linkY := read16(wram, 0x20)
+		linkLayer := read16(wram, 0xEE)
+		g.EntryCoord = AbsToMapCoord(linkX, linkY, linkLayer)
+		//fmt.Printf("  link coord = {%04x, %04x, %04x}\n", linkX, linkY, linkLayer)
+	}
+
+	g.Rooms = make([]*RoomState, 0, 0x20)
+
+	// build a stack (LIFO) of supertile entry points to visit:
+	lifo := make([]EntryPoint, 0, 0x100)
+	lifo = append(lifo, EntryPoint{g.Supertile, g.EntryCoord, DirNone, ExitPoint{}})
+
+	// process the LIFO:
+	for len(lifo) != 0 {
+		// pop off the stack:
+		lifoEnd := len(lifo) - 1
+		ep := lifo[lifoEnd]
+		lifo = lifo[0:lifoEnd]
+
+		this := ep.Supertile
+
+		//fmt.Printf("  ep = %s\n", ep)
+
+		// create a room:
+		var room *RoomState
+
+		c.supertilesLock.Lock()
+		var ok bool
+		if room, ok = c.supertiles[this]; ok {
+			//fmt.Printf("    reusing room %s\n", this)
+			//if eID != room.EntranceID {
+			//	panic(fmt.Errorf("conflicting entrances for room %s", st))
+			//}
+		} else {
+			// create new room:
+			var err error
+			if room, err = c.CreateRoom(this, e); err != nil {
+				c.supertilesLock.Unlock()
+				c.recordRoomFailure(eID, this, err)
+				continue
+			}
+			g.Rooms = append(g.Rooms, room)
+			c.supertiles[this] = room
+			c.roomsProgress.add(1)
+		}
+		c.supertilesLock.Unlock()
+
+		// emulate loading the room:
+		room.Lock()
+		if room.Err != nil {
+			// already failed to load from another entry point:
+			room.Unlock()
+			continue
+		}
+
+		fmt.Printf("entrance $%02x supertile %s discover from entry %s start\n", eID, room.Supertile, ep)
+
+		wasLoaded := room.IsLoaded
+		if err := room.Init(); err != nil {
+			room.Err = err
+			room.Unlock()
+			c.roomsProgress.step()
+			c.recordRoomFailure(eID, this, err)
+			continue
+		}
+		if !wasLoaded {
+			c.roomsProgress.step()
+		}
+
+		if exits, err := c.Reachability(room, ep); err != nil {
+			c.recordRoomFailure(eID, this, err)
+		} else {
+			lifo = append(lifo, exits...)
+			c.addLinks(this, exits)
+		}
+
+		fmt.Printf("entrance $%02x supertile %s discover from entry %s complete\n", eID, room.Supertile, ep)
+		room.Unlock()
+	}
+
+	return
+}
+
+// addLinks records the supertiles the exits of room st lead to:
+func (c *Config) addLinks(st Supertile, exits []EntryPoint) {
+	c.supertilesLock.Lock()
+	defer c.supertilesLock.Unlock()
+
+	for _, x := range exits {
+		c.links[st] = append(c.links[st], x.Supertile)
+	}
+}
+
+// drawEntranceRooms writes the PNGs and GIFs of the rooms discovered from entrance g:
+func (c *Config) drawEntranceRooms(g *Entrance, wg *sync.WaitGroup) {
+	eID := g.EntranceID
+
+	// render all supertiles found:
+	for _, room := range g.Rooms {
+		if c.DrawRoomPNGs || c.DrawBGLayerPNGs || c.SupertileGIFs || c.AnimateRoomDrawing {
+			r := room
+			c.gifsProgress.add(1)
+			c.goWork(wg, func() {
+				defer c.gifsProgress.step()
+
+				fmt.Printf("entrance $%02x supertile %s draw start\n", g.EntranceID, r.Supertile)
+
+				if err := r.ExportPNGs(); err != nil {
+					c.recordRoomFailure(g.EntranceID, r.Supertile, err)
+				}
+
+				if c.SupertileGIFs {
+					if err := render.RenderGIF(&r.GIF, c.OutputPath(fmt.Sprintf("%03x", uint16(r.Supertile)), "gif")); err != nil {
+						c.recordRoomFailure(g.EntranceID, r.Supertile, err)
+					}
+				}
+
+				if c.AnimateRoomDrawing {
+					if err := render.RenderGIF(&r.Animated, c.OutputPath(fmt.Sprintf("%03x.room", uint16(r.Supertile)), "gif")); err != nil {
+						c.recordRoomFailure(g.EntranceID, r.Supertile, err)
+					}
+				}
+
+				fmt.Printf("entrance $%02x supertile %s draw complete\n", g.EntranceID, r.Supertile)
+			})
+		}
+
+		// render VRAM BG tiles to a PNG:
+		if false {
+			pal := room.palette()
+
+			tiles := 0x4000 / 32
+			g := image.NewPaletted(image.Rect(0, 0, 16*8, (tiles/16)*8), pal)
+			for t := 0; t < tiles; t++ {
+				// palette 2
+				z := uint16(t) | (2 << 10)
+				render.Draw4bppTile(
+					g,
+					z,
+					(&room.VRAMTileSet)[:],
+					t%16,
+					t/16,
+				)
+			}
+
+			if err := render.ExportPNG(c.OutputPath(fmt.Sprintf("%03X.vram", uint16(room.Supertile)), "png"), g); err != nil {
+				c.recordRoomFailure(eID, room.Supertile, err)
+			}
+		}
+	}
+}
+
+type empty = struct{}
+
+// Entrance is an underworld entrance with the supertile and coordinate it starts at and every room discovered from it:
+type Entrance struct {
+	EntranceID uint8
+	Supertile
+
+	EntryCoord MapCoord
+
+	Rooms []*RoomState
+}
+
+func read16(b []byte, addr uint32) uint16 {
+	return binary.LittleEndian.Uint16(b[addr : addr+2])
+}
+
+func read8(b []byte, addr uint32) uint8 {
+	return b[addr]
+}
+
+func write8(b []byte, addr uint32, value uint8) {
+	b[addr] = value
+}
+
+func write16(b []byte, addr uint32, value uint16) {
+	binary.LittleEndian.PutUint16(b[addr:addr+2], value)
+}
+
+func write24(b []byte, addr uint32, value uint32) {
+	binary.LittleEndian.PutUint16(b[addr:addr+2], uint16(value&0x00FFFF))
+	b[addr+3] = byte(value >> 16)
+}
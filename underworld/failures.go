@@ -0,0 +1,73 @@
+package underworld
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"sort"
+	"strings"
+)
+
+// Failure records an entrance or room that could not be processed; the run carries on without it:
+type Failure struct {
+	EntranceID uint8
+	Supertile  Supertile
+	HasRoom    bool
+	Err        error
+}
+
+func (f Failure) String() string {
+	if f.HasRoom {
+		return fmt.Sprintf("entrance $%02x supertile %s: %v", f.EntranceID, f.Supertile, f.Err)
+	}
+	return fmt.Sprintf("entrance $%02x: %v", f.EntranceID, f.Err)
+}
+
+func (c *Config) recordEntranceFailure(eID uint8, err error) {
+	c.recordFailure(Failure{EntranceID: eID, Err: err})
+}
+
+func (c *Config) recordRoomFailure(eID uint8, st Supertile, err error) {
+	c.recordFailure(Failure{EntranceID: eID, Supertile: st, HasRoom: true, Err: err})
+}
+
+func (c *Config) recordFailure(f Failure) {
+	// print the emulation context in one go so parallel workers' output does not interleave:
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s failed\n", f)
+	var xe *emulator.ExecError
+	if errors.As(f.Err, &xe) {
+		xe.Dump(b)
+	}
+	fmt.Print(b.String())
+
+	c.failuresLock.Lock()
+	c.failures = append(c.failures, f)
+	c.failuresLock.Unlock()
+}
+
+// ReportFailures prints the end-of-run summary and returns an error if anything failed:
+func (c *Config) ReportFailures() error {
+	c.failuresLock.Lock()
+	defer c.failuresLock.Unlock()
+
+	failures := c.failures
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(failures, func(i, j int) bool {
+		if failures[i].EntranceID != failures[j].EntranceID {
+			return failures[i].EntranceID < failures[j].EntranceID
+		}
+		return failures[i].Supertile < failures[j].Supertile
+	})
+
+	fmt.Printf("%d failure(s):\n", len(failures))
+	for _, f := range failures {
+		fmt.Printf("  %s\n", f)
+	}
+
+	return fmt.Errorf("%d entrance(s) or room(s) failed; see summary above", len(failures))
+}
@@ -1,4 +1,4 @@
-package main
+package underworld
 
 type LinkState uint8
 
@@ -0,0 +1,72 @@
+package underworld
+
+import (
+	"github.com/alttpo/mapgen/alttp"
+	"runtime"
+	"sync"
+)
+
+// Config is the harness a run works with, the options controlling which rooms it processes and which artifacts
+// it produces, and the state it builds up; the CLI fills in the options from its flags. Make one with NewConfig:
+type Config struct {
+	Harness *alttp.Harness // returned by alttp.NewSystem
+
+	Selection Selection // restricts the entrances discovered from, the rooms kept and drawn, and scanning
+
+	// Workers bounds how many entrances, room GIFs and atlas rooms are worked on at once:
+	Workers int
+
+	// OutputPath returns the path an artifact is written to given its name, e.g. "03f" or "eg1", and file
+	// extension:
+	OutputPath func(name, ext string) string
+
+	// DoorPairs maps a doorway to the doorway it leads into when a door randomizer has rewired it; doorways
+	// not listed keep the vanilla neighbouring supertile:
+	DoorPairs map[Doorway]Doorway
+
+	DrawOverlays            bool // draw reachable tile overlays and exit labels on atlas images
+	DrawNumbers             bool // draw supertile numbers on atlas images
+	SupertileGIFs           bool // write a GIF per room showing its layers and room tag changes
+	AnimateRoomDrawing      bool // write a GIF per room animating the game drawing its objects
+	AnimateRoomDrawingDelay int
+	DrawRoomPNGs            bool // write a PNG per room
+	DrawBGLayerPNGs         bool // write a PNG per room BG layer and priority
+	DrawBG1p0               bool
+	DrawBG1p1               bool
+	DrawBG2p0               bool
+	DrawBG2p1               bool
+	OptimizeGIFs            bool // encode GIF frames as deltas of the previous frame
+	PaletteFromCGRAM        bool // draw rooms with the palette uploaded to CGRAM instead of the WRAM buffer
+	UseGammaRamp            bool // convert colors with the bsnes gamma ramp
+
+	supertiles     map[Supertile]*RoomState
+	links          map[Supertile][]Supertile // supertiles each room's exits lead to
+	supertilesLock sync.Mutex
+	roomsProgress  progress
+	gifsProgress   progress
+
+	failures     []Failure
+	failuresLock sync.Mutex
+
+	workerSlots     chan struct{}
+	workerSlotsOnce sync.Once
+}
+
+// NewConfig returns a Config with the default options for the harness h, which may be set later:
+func NewConfig(h *alttp.Harness) *Config {
+	return &Config{
+		Harness: h,
+		Workers: runtime.NumCPU(),
+		OutputPath: func(name, ext string) string {
+			return name + "." + ext
+		},
+		AnimateRoomDrawingDelay: 15,
+		DrawBG1p0:               true,
+		DrawBG1p1:               true,
+		DrawBG2p0:               true,
+		DrawBG2p1:               true,
+		OptimizeGIFs:            true,
+		roomsProgress:           progress{name: "rooms loaded"},
+		gifsProgress:            progress{name: "rooms drawn"},
+	}
+}
@@ -0,0 +1,48 @@
+package underworld
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// goWork runs f on its own goroutine once one of the Workers slots, shared by discovery, GIF rendering and atlas
+// composition, is free and marks wg done after.
+// the slot is acquired inside the goroutine so that work may queue more work without deadlocking:
+func (c *Config) goWork(wg *sync.WaitGroup, f func()) {
+	c.workerSlotsOnce.Do(func() {
+		n := c.Workers
+		if n < 1 {
+			n = 1
+		}
+		c.workerSlots = make(chan struct{}, n)
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		c.workerSlots <- struct{}{}
+		defer func() { <-c.workerSlots }()
+
+		f()
+	}()
+}
+
+// progress counts finished items against a total that may grow as more work is discovered:
+type progress struct {
+	// 64-bit counters first for atomic alignment:
+	done  int64
+	total int64
+	name  string
+}
+
+func (p *progress) add(n int) {
+	atomic.AddInt64(&p.total, int64(n))
+}
+
+func (p *progress) step() {
+	done := atomic.AddInt64(&p.done, 1)
+	total := atomic.LoadInt64(&p.total)
+	fmt.Printf("progress: %s %d/%d\n", p.name, done, total)
+}
@@ -0,0 +1,352 @@
+package underworld
+
+import "fmt"
+
+// Reachability flood fills a loaded room from an entry point, marking the tiles in room.Reachable and
+// recording the room's entry and exit points, and returns the entry points into other supertiles found.
+// The caller must hold the room's lock:
+func (c *Config) Reachability(room *RoomState, ep EntryPoint) (exits []EntryPoint, err error) {
+	this := ep.Supertile
+
+	// check if room causes pit damage vs warp:
+	pitDamages := room.PitDamages
+
+	warpExitTo := room.WarpExitTo
+	stairExitTo := &room.StairExitTo
+	warpExitLayer := room.WarpExitLayer
+	stairTargetLayer := &room.StairTargetLayer
+
+	pushEntryPoint := func(ep EntryPoint, name string) {
+		// for EG2:
+		if this >= 0x100 {
+			ep.Supertile |= 0x100
+		}
+
+		room.EntryPoints = append(room.EntryPoints, ep)
+		room.ExitPoints = append(room.ExitPoints, ExitPoint{
+			Supertile:    ep.Supertile,
+			Point:        ep.From.Point,
+			Direction:    ep.From.Direction,
+			WorthMarking: ep.From.WorthMarking,
+		})
+
+		exits = append(exits, ep)
+		//fmt.Printf("    %s to %s\n", name, ep)
+	}
+
+	// dont need to read interroom stair list from $06B0; just link stair tile number to STAIRnTO exit
+
+	// flood fill to find reachable tiles:
+	tiles := &room.Tiles
+	err = room.FindReachableTiles(
+		ep,
+		func(s ScanState, v uint8) error {
+			t := s.t
+			d := s.d
+
+			exit := ExitPoint{
+				ep.Supertile,
+				t,
+				d,
+				false,
+			}
+
+			// here we found a reachable tile:
+			room.Reachable[t] = v
+
+			if v == 0x00 {
+				// detect edge walkways:
+				if ok, edir, _, _ := t.IsEdge(); ok {
+					if sn, tn, sd, ok := c.transition(this, t, t.OppositeEdge(), edir); ok {
+						pushEntryPoint(EntryPoint{sn, tn, sd, exit}, fmt.Sprintf("%s walkway", edir))
+					}
+				}
+				return nil
+			}
+
+			// door objects:
+			if v >= 0xF0 {
+				//fmt.Printf("    door tile $%02x at %s\n", v, t)
+				// dungeon exits are already patched out, so this should be a normal door
+				lyr, row, col := t.RowCol()
+				if row >= 0x3A {
+					// south:
+					if sn, tn, sd, ok := c.transition(this, t, MapCoord(lyr|(0x06<<6)|col), DirSouth); ok {
+						pushEntryPoint(EntryPoint{sn, tn, sd, exit}, "south door")
+					}
+				} else if row <= 0x06 {
+					// north:
+					if sn, tn, sd, ok := c.transition(this, t, MapCoord(lyr|(0x3A<<6)|col), DirNorth); ok {
+						pushEntryPoint(EntryPoint{sn, tn, sd, exit}, "north door")
+					}
+				} else if col >= 0x3A {
+					// east:
+					if sn, tn, sd, ok := c.transition(this, t, MapCoord(lyr|(row<<6)|0x06), DirEast); ok {
+						pushEntryPoint(EntryPoint{sn, tn, sd, exit}, "east door")
+					}
+				} else if col <= 0x06 {
+					// west:
+					if sn, tn, sd, ok := c.transition(this, t, MapCoord(lyr|(row<<6)|0x3A), DirWest); ok {
+						pushEntryPoint(EntryPoint{sn, tn, sd, exit}, "west door")
+					}
+				}
+
+				return nil
+			}
+
+			// interroom doorways:
+			if (v >= 0x80 && v <= 0x8D) || (v >= 0x90 && v <= 97) {
+				if ok, edir, _, _ := t.IsDoorEdge(); ok && edir == d {
+					// at or beyond the door edge zones:
+					swapLayers := MapCoord(0)
+
+					{
+						// search the doorway for layer-swaps:
+						tn := t
+						for i := 0; i < 8; i++ {
+							vd := tiles[tn]
+							room.Reachable[tn] = vd
+							if vd >= 0x90 && vd <= 0x9F {
+								swapLayers = 0x1000
+							}
+							if vd >= 0xA8 && vd <= 0xAF {
+								swapLayers = 0x1000
+							}
+							if _, ok := room.SwapLayers[tn]; ok {
+								swapLayers = 0x1000
+							}
+
+							// advance into the doorway:
+							tn, _, ok = tn.MoveBy(edir, 1)
+							if !ok {
+								break
+							}
+						}
+					}
+
+					if v&1 == 0 {
+						// north-south normal doorway (no teleport doorways for north-south):
+						if sn, tn, sd, ok := c.transition(this, t, t.OnEdge(edir.Opposite())^swapLayers, edir); ok {
+							pushEntryPoint(EntryPoint{sn, tn, sd, exit}, "north-south doorway")
+						}
+					} else {
+						// east-west doorway:
+						if v == 0x89 {
+							// teleport doorway:
+							if edir == DirWest {
+								pushEntryPoint(EntryPoint{stairExitTo[2], t.OnEdge(edir.Opposite()) ^ swapLayers, edir, exit}, "west teleport doorway")
+							} else if edir == DirEast {
+								pushEntryPoint(EntryPoint{stairExitTo[3], t.OnEdge(edir.Opposite()) ^ swapLayers, edir, exit}, "east teleport doorway")
+							} else {
+								return fmt.Errorf("invalid direction %s approaching east-west teleport doorway at %s", edir, t)
+							}
+						} else {
+							// normal doorway:
+							if sn, tn, sd, ok := c.transition(this, t, t.OnEdge(edir.Opposite())^swapLayers, edir); ok {
+								pushEntryPoint(EntryPoint{sn, tn, sd, exit}, "east-west doorway")
+							}
+						}
+					}
+				}
+				return nil
+			}
+
+			if v >= 0x30 && v < 0x38 {
+				var vn uint8
+				vn = tiles[t-0x40]
+				if vn == 0x80 || vn == 0x26 {
+					vn = tiles[t+0x40]
+				}
+
+				if vn == 0x5E || vn == 0x5F {
+					// spiral staircase
+					tgtLayer := stairTargetLayer[v&3]
+					dt := t
+					if v&4 == 0 {
+						// going up
+						if t&0x1000 != 0 {
+							dt += 0x80
+						}
+						if tgtLayer != 0 {
+							dt += 0x80
+						}
+						pushEntryPoint(EntryPoint{stairExitTo[v&3], dt&0x0FFF | tgtLayer, d.Opposite(), exit}, fmt.Sprintf("spiralStair(%s)", t))
+					} else {
+						// going down
+						if t&0x1000 != 0 {
+							dt -= 0x80
+						}
+						if tgtLayer != 0 {
+							dt -= 0x80
+						}
+						pushEntryPoint(EntryPoint{stairExitTo[v&3], dt&0x0FFF | tgtLayer, d.Opposite(), exit}, fmt.Sprintf("spiralStair(%s)", t))
+					}
+					return nil
+				} else if vn == 0x38 {
+					// north stairs:
+					tgtLayer := stairTargetLayer[v&3]
+					dt := t.Col() + 0xFC0 - 2<<6
+					if v&4 == 0 {
+						// going up
+						if t&0x1000 != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt -= 4 << 6
+						}
+						if tgtLayer != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt -= 4 << 6
+						}
+					} else {
+						// going down
+						// module #$07 submodule #$12 is going down north stairs (e.g. $042)
+						if t&0x1000 != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt += 4 << 6
+						}
+						if tgtLayer != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt += 4 << 6
+						}
+					}
+					pushEntryPoint(EntryPoint{stairExitTo[v&3], dt&0x0FFF | tgtLayer, d, exit}, fmt.Sprintf("northStair(%s)", t))
+					return nil
+				} else if vn == 0x39 {
+					// south stairs:
+					tgtLayer := stairTargetLayer[v&3]
+					dt := t.Col() + 2<<6
+					if v&4 == 0 {
+						// going up
+						if t&0x1000 != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt -= 4 << 6
+						}
+						if tgtLayer != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt -= 4 << 6
+						}
+					} else {
+						// going down
+						if t&0x1000 != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt += 4 << 6
+						}
+						if tgtLayer != 0 {
+							// 32 pixels = 4 8x8 tiles
+							dt += 4 << 6
+						}
+					}
+					pushEntryPoint(EntryPoint{stairExitTo[v&3], dt&0x0FFF | tgtLayer, d, exit}, fmt.Sprintf("southStair(%s)", t))
+					return nil
+				} else if vn == 0x00 {
+					// straight stairs:
+					pushEntryPoint(EntryPoint{stairExitTo[v&3], t&0x0FFF | stairTargetLayer[v&3], d.Opposite(), exit}, fmt.Sprintf("stair(%s)", t))
+					return nil
+				}
+				return fmt.Errorf("unhandled stair exit at %s %s", t, d)
+			}
+
+			// pit exits:
+			if !pitDamages {
+				if v == 0x20 {
+					// pit tile
+					exit.WorthMarking = !room.markedPit
+					room.markedPit = true
+					pushEntryPoint(EntryPoint{warpExitTo, t&0x0FFF | warpExitLayer, d, exit}, fmt.Sprintf("pit(%s)", t))
+					return nil
+				} else if v == 0x62 {
+					// bombable floor tile
+					exit.WorthMarking = !room.markedFloor
+					room.markedFloor = true
+					pushEntryPoint(EntryPoint{warpExitTo, t&0x0FFF | warpExitLayer, d, exit}, fmt.Sprintf("bombableFloor(%s)", t))
+					return nil
+				}
+			}
+			if v == 0x4B {
+				// warp floor tile
+				exit.WorthMarking = t&0x40 == 0 && t&0x01 == 0
+				pushEntryPoint(EntryPoint{warpExitTo, t&0x0FFF | warpExitLayer, d, exit}, fmt.Sprintf("warp(%s)", t))
+				return nil
+			}
+
+			if true {
+				// manipulables (pots, hammer pegs, push blocks):
+				if v&0xF0 == 0x70 {
+					// find gfx tilemap position:
+					j := (uint32(v) & 0x0F) << 1
+					p := read16(room.WRAM[:], 0x0500+j)
+					//fmt.Printf("    manip(%s) %02x = %04x\n", t, v, p)
+					if p == 0 {
+						//fmt.Printf("    pushBlock(%s)\n", t)
+
+						// push block flips 0x0641
+						write8(room.WRAM[:], 0x0641, 0x01)
+						if read8(room.WRAM[:], 0xAE)|read8(room.WRAM[:], 0xAF) != 0 {
+							// handle tags if there are any after the push to see if it triggers a secret:
+							if _, err := room.HandleRoomTags(); err != nil {
+								return err
+							}
+							// TODO: properly determine which tag was activated
+							room.TilesVisited = room.TilesVisitedTag0
+						}
+					}
+					return nil
+				}
+
+				v16 := read16(room.Tiles[:], uint32(t))
+				if v16 == 0x3A3A || v16 == 0x3B3B {
+					//fmt.Printf("    star(%s)\n", t)
+
+					// set absolute x,y coordinates to the tile:
+					x, y := t.ToAbsCoord(room.Supertile)
+					write16(room.WRAM[:], 0x20, y)
+					write16(room.WRAM[:], 0x22, x)
+					write16(room.WRAM[:], 0xEE, (uint16(t)&0x1000)>>10)
+
+					if _, err := room.HandleRoomTags(); err != nil {
+						return err
+					}
+
+					// swap out visited maps:
+					if read8(room.WRAM[:], 0x04BC) == 0 {
+						//fmt.Printf("    star0\n")
+						room.TilesVisited = room.TilesVisitedStar0
+						//ioutil.WriteFile(fmt.Sprintf("data/%03X.cmap0", uint16(this)), room.Tiles[:], 0644)
+					} else {
+						//fmt.Printf("    star1\n")
+						room.TilesVisited = room.TilesVisitedStar1
+						//ioutil.WriteFile(fmt.Sprintf("data/%03X.cmap1", uint16(this)), room.Tiles[:], 0644)
+					}
+					return nil
+				}
+
+				// floor or pressure switch:
+				if v16 == 0x2323 || v16 == 0x2424 {
+					//fmt.Printf("    switch(%s)\n", t)
+
+					// set absolute x,y coordinates to the tile:
+					x, y := t.ToAbsCoord(room.Supertile)
+					write16(room.WRAM[:], 0x20, y)
+					write16(room.WRAM[:], 0x22, x)
+					write16(room.WRAM[:], 0xEE, (uint16(t)&0x1000)>>10)
+
+					if activated, err := room.HandleRoomTags(); err != nil {
+						return err
+					} else if activated {
+						// reset current room visited state:
+						for i := range room.TilesVisited {
+							delete(room.TilesVisited, i)
+						}
+						//ioutil.WriteFile(fmt.Sprintf("data/%03X.cmap0", uint16(this)), room.Tiles[:], 0644)
+					}
+					return nil
+				}
+			}
+
+			return nil
+		},
+	)
+
+	//ioutil.WriteFile(fmt.Sprintf("data/%03X.rch", uint16(this)), room.Reachable[:], 0644)
+
+	return
+}
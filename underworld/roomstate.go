@@ -1,7 +1,16 @@
-package main
+// Package underworld models ALTTP underworld supertiles loaded by the game code running in the emulator: it
+// discovers the rooms reachable from each entrance, flood fills the tiles Link can reach and draws rooms and atlases.
+//
+// Everything works from a Config holding the harness returned by alttp.NewSystem and the run's options. A single
+// room is loaded with Config.LoadRoom and flood filled from an entry point with Config.Reachability;
+// Config.DiscoverEntrances does this for every entrance, following the exits found into neighbouring supertiles.
+package underworld
 
 import (
 	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/mapgen/render"
+	"github.com/alttpo/snes/mapping/lorom"
 	"image"
 	"image/color"
 	"image/gif"
@@ -39,9 +48,13 @@ type RoomState struct {
 
 	Supertile
 
-	IsLoaded bool
+	IsLoaded   bool
+	Err        error // set when the room failed to load
+	PitDamages bool  // pits damage Link instead of dropping him to WarpExitTo
 
-	Rendered image.Image
+	Rendered  image.Image
+	bgLayers  [4]*image.Paletted // BG1 and BG2 priority layers as loaded, kept for ExportPNGs
+	Scanlines []emulator.PPURegs // per-line PPU registers from HDMA for the frame being captured, if any
 	gif.GIF
 
 	Animated        gif.GIF // single room drawing animation
@@ -73,22 +86,24 @@ type RoomState struct {
 	Reachable [0x2000]byte
 	Hookshot  map[MapCoord]byte
 
-	e           System
+	cfg         *Config
+	e           emulator.System
 	WRAM        [0x20000]byte
 	VRAMTileSet [0x4000]byte
 
 	markedPit   bool
 	markedFloor bool
+	asLoaded    bool // analyze the supertile as it stands in WRAM rather than loading it first
 	lifoSpace   [0x2000]ScanState
 	lifo        []ScanState
 }
 
-func CreateRoom(st Supertile, initEmu *System) (room *RoomState) {
-	var err error
-
+// CreateRoom prepares supertile st to be loaded by Init from the state of initEmu, which is not modified:
+func (c *Config) CreateRoom(st Supertile, initEmu *emulator.System) (room *RoomState, err error) {
 	//fmt.Printf("    creating room %s\n", st)
 
 	room = &RoomState{
+		cfg:               c,
 		Supertile:         st,
 		Rendered:          nil,
 		Hookshot:          make(map[MapCoord]byte, 0x2000),
@@ -99,23 +114,102 @@ func CreateRoom(st Supertile, initEmu *System) (room *RoomState) {
 	}
 	room.TilesVisited = room.TilesVisitedStar0
 
+	// RoomsWithPitDamage#_00990C [0x70]uint16
+	romaddr, _ := lorom.BusAddressToPak(c.Harness.Profile.RoomsWithPitDamage)
+	for i := uint32(0); i <= 0x70; i++ {
+		if Supertile(read16(initEmu.ROM, romaddr+i<<1)) == st {
+			room.PitDamages = true
+			break
+		}
+	}
+
 	e := &room.e
 
 	// have the emulator's WRAM refer to room.WRAM
 	e.WRAM = &room.WRAM
 	if err = e.InitEmulatorFrom(initEmu); err != nil {
-		panic(err)
+		return
 	}
 
 	return
 }
 
+// LoadRoom loads and draws supertile st as the game would after loading entrance eID, using that entrance's
+// graphics and state. The harness's system is not modified:
+func (c *Config) LoadRoom(eID uint8, st Supertile) (room *RoomState, err error) {
+	e := &emulator.System{}
+	if err = e.InitEmulatorFrom(c.Harness.System); err != nil {
+		return
+	}
+	if err = c.Harness.LoadEntrance(e, eID); err != nil {
+		return
+	}
+
+	if room, err = c.CreateRoom(st, e); err != nil {
+		return
+	}
+	if err = room.Init(); err != nil {
+		return
+	}
+	err = room.ExportPNGs()
+	return
+}
+
+// LoadRoomFromState analyzes and draws supertile st as it stands in the state in a file written by SaveState
+// or by emulator.System.SaveStateFile, like LoadImportedRoom, without loading an entrance or the room again. The
+// harness's system is not modified:
+func (c *Config) LoadRoomFromState(path string, st Supertile) (room *RoomState, err error) {
+	e := &emulator.System{}
+	if err = e.InitEmulatorFrom(c.Harness.System); err != nil {
+		return
+	}
+	if err = e.LoadStateFile(path); err != nil {
+		return
+	}
+	if loaded := Supertile(e.ReadWRAM16(0xA0)); loaded != st {
+		err = fmt.Errorf("underworld: %s has supertile %s loaded, not %s", path, loaded, st)
+		return
+	}
+
+	if room, err = c.CreateRoom(st, e); err != nil {
+		return
+	}
+	room.asLoaded = true
+	if err = room.Init(); err != nil {
+		return
+	}
+	err = room.ExportPNGs()
+	return
+}
+
+// LoadImportedRoom analyzes and draws supertile st as it already stands in e's WRAM and VRAM, e.g. imported
+// from another emulator's save state, without running the game's room loading first. e is not modified:
+func (c *Config) LoadImportedRoom(e *emulator.System, st Supertile) (room *RoomState, err error) {
+	if room, err = c.CreateRoom(st, e); err != nil {
+		return
+	}
+	room.asLoaded = true
+	if err = room.Init(); err != nil {
+		return
+	}
+	err = room.ExportPNGs()
+	return
+}
+
+// SaveState writes the room's emulator state as it stands, e.g. after loading or after a tag fired, to a file
+// that LoadRoomFromState can load:
+func (room *RoomState) SaveState(path string) error {
+	return room.e.SaveStateFile(path)
+}
+
 func (room *RoomState) Init() (err error) {
 	if room.IsLoaded {
 		return
 	}
 
 	st := room.Supertile
+	c := room.cfg
+	p := c.Harness.Profile
 
 	e := &room.e
 	wram := (e.WRAM)[:]
@@ -123,9 +217,13 @@ func (room *RoomState) Init() (err error) {
 	tiles := room.Tiles[:]
 
 	// load and draw current supertile:
-	write16(wram, 0xA0, uint16(st))
+	if !room.asLoaded {
+		write16(wram, 0xA0, uint16(st))
+	}
 
-	if animateRoomDrawing {
+	// rooms only passed through to reach the selected ones are not animated:
+	animate := c.AnimateRoomDrawing && !room.asLoaded && (c.Selection.IncludesSupertile(st) || c.Selection.Reachable)
+	if animate {
 		// clear tile map first:
 		tilemap := e.WRAM[0x2000:0x6000]
 		for i := range tilemap {
@@ -145,7 +243,7 @@ func (room *RoomState) Init() (err error) {
 
 		//#_018834: JSR RoomDraw_DrawAllObjects
 		//#_018837: PLY
-		e.CPU.OnPC[0x01_8837] = func() {
+		e.CPU.OnPC[p.RoomDrawAfterAllObjects] = func() {
 			// start capturing after basic room layout (template) is drawn:
 			captureStart = true
 			room.AnimatedLayer++
@@ -153,40 +251,42 @@ func (room *RoomState) Init() (err error) {
 		}
 
 		// draw layer 2:
-		e.CPU.OnPC[0x01_885F] = func() {
+		e.CPU.OnPC[p.RoomDrawLayer2] = func() {
 			room.AnimatedLayer++
 			//doCapture()
 		}
 		// draw layer 3 (aka doors):
-		e.CPU.OnPC[0x01_8874] = func() {
+		e.CPU.OnPC[p.RoomDrawLayer3] = func() {
 			room.AnimatedLayer++
 			//doCapture()
 		}
 
 		//RoomDraw_A_Many32x32Blocks:#_018A44
 		//#_018A88: RTS
-		e.CPU.OnPC[0x01_8A88] = doCapture
+		e.CPU.OnPC[p.RoomDrawMany32x32BlocksRTS] = doCapture
 
 		//#_01880F: JSR RoomDraw_DrawFloors
 		//#_018812: LDY.b $BA
-		e.CPU.OnPC[0x01_8812] = doCapture
+		e.CPU.OnPC[p.RoomDrawAfterFloors] = doCapture
 
 		//#_0188F8: JSR RoomData_DrawObject
 		//#_0188FB: BRA .next
-		e.CPU.OnPC[0x01_88FB] = doCapture
+		e.CPU.OnPC[p.RoomDrawAfterObject] = doCapture
 
 		//#_01890D: JSR RoomData_DrawObject_Door
 		//#_018910: INC.b $BA
-		e.CPU.OnPC[0x01_8910] = doCapture
+		e.CPU.OnPC[p.RoomDrawAfterDoor] = doCapture
 	}
 
 	//e.LoggerCPU = e.Logger
-	if err = e.ExecAt(loadSupertilePC, donePC); err != nil {
-		return
+	if !room.asLoaded {
+		if err = e.ExecAt(c.Harness.LoadSupertilePC, c.Harness.DonePC); err != nil {
+			return
+		}
 	}
 	//e.LoggerCPU = nil
 
-	if animateRoomDrawing {
+	if animate {
 		// capture final frame:
 		room.CaptureRoomDrawFrame()
 
@@ -378,8 +478,8 @@ func (room *RoomState) Init() (err error) {
 					//fmt.Printf("    blow open %s\n", MapCoord(int(tn)+adj))
 					tiles[int(tn)+adj] = doorwayTile
 				} else {
-					panic(fmt.Errorf("something blocking the doorway at %s: $%02x", tn, v))
-					break
+					err = fmt.Errorf("something blocking the doorway at %s: $%02x", tn, v)
+					return
 				}
 
 				tn, _, ok = tn.MoveBy(door.Dir, 1)
@@ -612,16 +712,20 @@ func (room *RoomState) Init() (err error) {
 	}
 
 	// capture first room state:
-	room.DrawSupertile()
+	if err = room.DrawSupertile(); err != nil {
+		return
+	}
 
-	room.RenderAnimatedRoomDraw(animateRoomDrawingDelay)
+	room.RenderAnimatedRoomDraw(c.AnimateRoomDrawingDelay)
 
 	f := len(room.GIF.Delay) - 1
 	if f >= 0 {
 		room.GIF.Delay[f] += 200
 	}
 
-	room.HandleRoomTags()
+	if _, err = room.HandleRoomTags(); err != nil {
+		return
+	}
 
 	//ioutil.WriteFile(fmt.Sprintf("data/%03X.cmap", uint16(st)), (&room.Tiles)[:], 0644)
 
@@ -727,8 +831,8 @@ func (r *RoomState) isHookable(v uint8) bool {
 
 func (r *RoomState) FindReachableTiles(
 	entryPoint EntryPoint,
-	visit func(s ScanState, v uint8),
-) {
+	visit func(s ScanState, v uint8) error,
+) (err error) {
 	m := &r.Tiles
 
 	// if we ever need to wrap
@@ -754,7 +858,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0x00 || v == 0x01 {
 				// continue in the same direction:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 				if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
 					r.push(ScanState{t: tn, d: dir, s: StatePipe})
 				}
@@ -764,7 +870,9 @@ func (r *RoomState) FindReachableTiles(
 			// straight:
 			if v == 0xB0 || v == 0xB1 {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// check for pipe exit 3 tiles in advance:
 				// this is done to skip collision tiles between B0/B1 and BE
@@ -791,7 +899,9 @@ func (r *RoomState) FindReachableTiles(
 			// west to south or north to east:
 			if v == 0xB2 {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if s.d == DirWest {
 					if tn, dir, ok := s.t.MoveBy(DirSouth, 1); ok {
@@ -807,7 +917,9 @@ func (r *RoomState) FindReachableTiles(
 			// south to east or west to north:
 			if v == 0xB3 {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if s.d == DirSouth {
 					if tn, dir, ok := s.t.MoveBy(DirEast, 1); ok {
@@ -823,7 +935,9 @@ func (r *RoomState) FindReachableTiles(
 			// north to west or east to south:
 			if v == 0xB4 {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if s.d == DirNorth {
 					if tn, dir, ok := s.t.MoveBy(DirWest, 1); ok {
@@ -839,7 +953,9 @@ func (r *RoomState) FindReachableTiles(
 			// east to north or south to west:
 			if v == 0xB5 {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if s.d == DirEast {
 					if tn, dir, ok := s.t.MoveBy(DirNorth, 1); ok {
@@ -856,7 +972,9 @@ func (r *RoomState) FindReachableTiles(
 			// line exit:
 			if v == 0xB6 {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// check for 2 pit tiles beyond exit:
 				t := s.t
@@ -879,7 +997,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xB7 {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if tn, dir, ok := s.t.MoveBy(DirSouth, 1); ok {
 					r.push(ScanState{t: tn, d: dir, s: StatePipe})
@@ -897,7 +1017,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xB8 {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if tn, dir, ok := s.t.MoveBy(DirNorth, 1); ok {
 					r.push(ScanState{t: tn, d: dir, s: StatePipe})
@@ -915,7 +1037,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xB9 {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if tn, dir, ok := s.t.MoveBy(DirNorth, 1); ok {
 					r.push(ScanState{t: tn, d: dir, s: StatePipe})
@@ -933,7 +1057,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xBA {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if tn, dir, ok := s.t.MoveBy(DirNorth, 1); ok {
 					r.push(ScanState{t: tn, d: dir, s: StatePipe})
@@ -951,7 +1077,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xBB {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if tn, dir, ok := s.t.MoveBy(DirNorth, 1); ok {
 					r.push(ScanState{t: tn, d: dir, s: StatePipe})
@@ -972,7 +1100,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xBC {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// continue in the same direction:
 				if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
@@ -1002,7 +1132,9 @@ func (r *RoomState) FindReachableTiles(
 			if v == 0xBD {
 				// do not mark as visited in case we cross from the other direction later:
 				//r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// continue in the same direction:
 				if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
@@ -1014,7 +1146,9 @@ func (r *RoomState) FindReachableTiles(
 			// pipe exit:
 			if v == 0xBE {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// continue in the same direction but not in pipe-follower state:
 				if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
@@ -1028,7 +1162,8 @@ func (r *RoomState) FindReachableTiles(
 
 		if s.s == StateSwim {
 			if s.t&0x1000 == 0 {
-				panic("swimming in layer 1!")
+				err = fmt.Errorf("swimming in layer 1 at %s", s.t)
+				return
 			}
 
 			if v == 0x02 || v == 0x03 {
@@ -1039,7 +1174,9 @@ func (r *RoomState) FindReachableTiles(
 
 			if v == 0x0A {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// flip to walking:
 				t := s.t & ^MapCoord(0x1000)
@@ -1052,7 +1189,9 @@ func (r *RoomState) FindReachableTiles(
 
 			if v == 0x1D {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// flip to walking:
 				t := s.t & ^MapCoord(0x1000)
@@ -1065,7 +1204,9 @@ func (r *RoomState) FindReachableTiles(
 
 			if v == 0x3D {
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				// flip to walking:
 				t := s.t & ^MapCoord(0x1000)
@@ -1078,7 +1219,9 @@ func (r *RoomState) FindReachableTiles(
 
 			// can swim over mostly everything on layer 2:
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 			r.pushAllDirections(s.t, StateSwim)
 			continue
 		}
@@ -1086,7 +1229,9 @@ func (r *RoomState) FindReachableTiles(
 		if v == 0x08 {
 			// deep water:
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// flip to swimming layer and state:
 			t := s.t ^ 0x1000
@@ -1104,7 +1249,9 @@ func (r *RoomState) FindReachableTiles(
 		if r.isAlwaysWalkable(v) || r.isMaybeWalkable(s.t, v) {
 			// no collision:
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// can move in any direction:
 			r.pushAllDirections(s.t, StateWalk)
@@ -1122,7 +1269,9 @@ func (r *RoomState) FindReachableTiles(
 		if v == 0x0A {
 			// deep water ladder:
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// transition to swim state on other layer:
 			t := s.t | 0x1000
@@ -1138,7 +1287,9 @@ func (r *RoomState) FindReachableTiles(
 		// layer pass through:
 		if v == 0x1C {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if s.t&0x1000 == 0 {
 				// $1C falling onto $0C means scrolling floor:
@@ -1156,13 +1307,16 @@ func (r *RoomState) FindReachableTiles(
 
 			continue
 		} else if v == 0x0C {
-			panic(fmt.Errorf("what to do for $0C at %s", s.t))
+			err = fmt.Errorf("what to do for $0C at %s", s.t)
+			return
 		}
 
 		// north-facing stairs:
 		if v == 0x1D {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
 				r.push(ScanState{t: tn, d: dir})
@@ -1172,7 +1326,9 @@ func (r *RoomState) FindReachableTiles(
 		// north-facing stairs, layer changing:
 		if v >= 0x1E && v <= 0x1F {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if tn, dir, ok := s.t.MoveBy(s.d, 2); ok {
 				// swap layers:
@@ -1190,7 +1346,9 @@ func (r *RoomState) FindReachableTiles(
 			// TODO: fix this to accommodate both position and direction in the visited[] check and introduce
 			// a Falling direction
 			//r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// check what's beyond the pit:
 			func() {
@@ -1242,7 +1400,9 @@ func (r *RoomState) FindReachableTiles(
 			}
 
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// check for hookable tiles across from this ledge:
 			r.scanHookshot(s.t, s.d)
@@ -1269,7 +1429,8 @@ func (r *RoomState) FindReachableTiles(
 					r.push(ScanState{t: t, d: dir})
 				}
 			} else if v == 0x0C {
-				panic(fmt.Errorf("TODO handle $0C in pit case t=%s", t))
+				err = fmt.Errorf("TODO handle $0C in pit case t=%s", t)
+				return
 			} else if v == 0x00 {
 				// open floor:
 				r.push(ScanState{t: t, d: dir})
@@ -1281,7 +1442,9 @@ func (r *RoomState) FindReachableTiles(
 		// interroom stair exits:
 		if v >= 0x30 && v <= 0x37 {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// don't continue beyond a staircase unless it's our entry point:
 			if len(r.lifo) == 0 {
@@ -1295,7 +1458,9 @@ func (r *RoomState) FindReachableTiles(
 		// 38=Straight interroom stairs north/down edge (39= south/up edge):
 		if v == 0x38 || v == 0x39 {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// don't continue beyond a staircase unless it's our entry point:
 			if len(r.lifo) == 0 {
@@ -1309,7 +1474,9 @@ func (r *RoomState) FindReachableTiles(
 		// south-facing single-layer auto stairs:
 		if v == 0x3D {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
 				r.push(ScanState{t: tn, d: dir})
@@ -1319,7 +1486,9 @@ func (r *RoomState) FindReachableTiles(
 		// south-facing layer-swap auto stairs:
 		if v >= 0x3E && v <= 0x3F {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if tn, dir, ok := s.t.MoveBy(s.d, 2); ok {
 				// swap layers:
@@ -1333,7 +1502,9 @@ func (r *RoomState) FindReachableTiles(
 		// $5F is the layer 2 version of $5E (spiral staircase)
 		if v == 0x5E || v == 0x5F {
 			r.TilesVisited[s.t] = empty{}
-			f(s, m[s.t])
+			if err = f(s, m[s.t]); err != nil {
+				return
+			}
 
 			if tn, dir, ok := s.t.MoveBy(s.d, 1); ok {
 				r.push(ScanState{t: tn, d: dir})
@@ -1357,11 +1528,14 @@ func (r *RoomState) FindReachableTiles(
 				}
 
 				if s.d != DirNorth && s.d != DirSouth {
-					panic(fmt.Errorf("north-south door approached from perpendicular direction %s at %s", s.d, s.t))
+					err = fmt.Errorf("north-south door approached from perpendicular direction %s at %s", s.d, s.t)
+					return
 				}
 
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if ok, edir, _, _ := s.t.IsDoorEdge(); ok && edir == s.d {
 					// don't move past door edge:
@@ -1384,11 +1558,14 @@ func (r *RoomState) FindReachableTiles(
 				}
 
 				if s.d != DirEast && s.d != DirWest {
-					panic(fmt.Errorf("east-west door approached from perpendicular direction %s at %s", s.d, s.t))
+					err = fmt.Errorf("east-west door approached from perpendicular direction %s at %s", s.d, s.t)
+					return
 				}
 
 				r.TilesVisited[s.t] = empty{}
-				f(s, v)
+				if err = f(s, v); err != nil {
+					return
+				}
 
 				if ok, edir, _, _ := s.t.IsDoorEdge(); ok && edir == s.d {
 					// don't move past door edge:
@@ -1403,7 +1580,9 @@ func (r *RoomState) FindReachableTiles(
 		// east-west teleport door
 		if v == 0x89 {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if ok, edir, _, _ := s.t.IsDoorEdge(); ok && edir == s.d {
 				// don't move past door edge:
@@ -1417,7 +1596,9 @@ func (r *RoomState) FindReachableTiles(
 		// entrance door (8E = north-south?, 8F = east-west??):
 		if v == 0x8E || v == 0x8F {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if s.d == DirNone {
 				// scout in both directions:
@@ -1443,7 +1624,9 @@ func (r *RoomState) FindReachableTiles(
 		// Layer/dungeon toggle doorways:
 		if v >= 0x90 && v <= 0xAF {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if ok, edir, _, _ := s.t.IsDoorEdge(); ok && edir == s.d {
 				// don't move past door edge:
@@ -1458,7 +1641,9 @@ func (r *RoomState) FindReachableTiles(
 		// TR pipe entrance:
 		if v == 0xBE {
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			// find corresponding B0..B1 directional pipe to follow:
 			if tn, dir, ok := s.t.MoveBy(DirNorth, 1); ok && (m[tn] >= 0xB0 && m[tn] <= 0xB1) {
@@ -1516,7 +1701,9 @@ func (r *RoomState) FindReachableTiles(
 			}
 
 			r.TilesVisited[s.t] = empty{}
-			f(s, v)
+			if err = f(s, v); err != nil {
+				return
+			}
 
 			if t, _, ok := s.t.MoveBy(s.d, 2); ok {
 				r.push(ScanState{t: t, d: s.d})
@@ -1528,6 +1715,8 @@ func (r *RoomState) FindReachableTiles(
 		//r.TilesVisited[s.t] = empty{}
 		continue
 	}
+
+	return
 }
 
 func (r *RoomState) scanHookshot(t MapCoord, d Direction) {
@@ -1594,13 +1783,13 @@ func (r *RoomState) scanHookshot(t MapCoord, d Direction) {
 	}
 }
 
-func (r *RoomState) HandleRoomTags() bool {
+func (r *RoomState) HandleRoomTags() (activated bool, err error) {
 	e := &r.e
 
 	// if no tags present, don't check them:
 	oldAE, oldAF := read8(r.WRAM[:], 0xAE), read8(r.WRAM[:], 0xAF)
 	if oldAE == 0 && oldAF == 0 {
-		return false
+		return
 	}
 
 	old04BC := read8(r.WRAM[:], 0x04BC)
@@ -1615,7 +1804,7 @@ func (r *RoomState) HandleRoomTags() bool {
 	}
 
 	// insert a blank GIF frame so its delay may be adjusted:
-	r.GIF.Image = append(r.GIF.Image, newBlankFrame())
+	r.GIF.Image = append(r.GIF.Image, render.NewBlankFrame())
 	r.GIF.Delay = append(r.GIF.Delay, 50)
 	r.GIF.Disposal = append(r.GIF.Disposal, 0)
 
@@ -1623,6 +1812,7 @@ func (r *RoomState) HandleRoomTags() bool {
 	lastDelay := 167
 	copy(lastCap[:], e.WRAM[0x2000:0x6000])
 
+	var drawErr error
 	e.CPU.OnWDM = func(wdm byte) {
 		// capture frame to GIF:
 		if wdm == 0xFF {
@@ -1652,17 +1842,25 @@ func (r *RoomState) HandleRoomTags() bool {
 				}
 			}
 			lastDelay = 167
-			r.DrawSupertile()
+			r.Scanlines = e.HWIO.RunHDMAFrame()
+			if err := r.DrawSupertile(); err != nil && drawErr == nil {
+				drawErr = err
+			}
+			r.Scanlines = nil
 
 			copy(lastCap[:], e.WRAM[0x2000:0x6000])
 		}
 	}
 
-	if err := e.ExecAt(b00HandleRoomTagsPC, 0); err != nil {
-		panic(err)
-	}
-
+	err = e.ExecAt(r.cfg.Harness.HandleRoomTagsPC, 0)
 	e.CPU.OnWDM = nil
+	if err != nil {
+		return
+	}
+	if drawErr != nil {
+		err = drawErr
+		return
+	}
 
 	// update room state:
 	copy(r.Tiles[:], e.WRAM[0x12000:0x14000])
@@ -1670,13 +1868,15 @@ func (r *RoomState) HandleRoomTags() bool {
 	// if $AE or $AF (room tags) are modified, then the tag was activated:
 	newAE, newAF := read8(r.WRAM[:], 0xAE), read8(r.WRAM[:], 0xAF)
 	if newAE != oldAE || newAF != oldAF {
-		return true
+		activated = true
+		return
 	}
 
 	new04BC := read8(r.WRAM[:], 0x04BC)
 	if new04BC != old04BC {
-		return true
+		activated = true
+		return
 	}
 
-	return false
+	return
 }
@@ -0,0 +1,56 @@
+package underworld
+
+import (
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"github.com/alttpo/mapgen/render"
+	"io/ioutil"
+)
+
+// ScanForTileTypes loads every selected supertile and writes out the tile type maps containing tileType:
+func (c *Config) ScanForTileTypes(tileType uint8) (err error) {
+	h := c.Harness
+	e := &emulator.System{}
+	if err = e.InitEmulatorFrom(h.System); err != nil {
+		return
+	}
+
+	// scan underworld for certain tile types:
+	if err = h.LoadEntrance(e, 0x00); err != nil {
+		return
+	}
+
+	for st := uint16(0); st < 0x128; st++ {
+		if !c.Selection.IncludesSupertile(Supertile(st)) {
+			continue
+		}
+
+		// load and draw current supertile:
+		write16(e.HWIO.Dyn[:], h.LoadAndDrawRoomSetSupertilePC-0x01_5000, st)
+		if err = e.ExecAt(h.LoadAndDrawRoomPC, 0); err != nil {
+			err = fmt.Errorf("supertile %s: %w", Supertile(st), err)
+			return
+		}
+
+		found := false
+		for t, v := range e.WRAM[0x12000:0x14000] {
+			if v == tileType {
+				found = true
+				fmt.Printf("%s: %s = $%02X\n", Supertile(st), MapCoord(t), tileType)
+			}
+		}
+
+		if found {
+			path := c.OutputPath(fmt.Sprintf("%03x", st), "tmap")
+			if err = render.CreateParentDir(path); err != nil {
+				return
+			}
+			if err = ioutil.WriteFile(path, e.WRAM[0x12000:0x14000], 0644); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+
+}
@@ -0,0 +1,18 @@
+package underworld
+
+// Selection restricts a run to a subset of entrances and supertiles; nil sets select everything:
+type Selection struct {
+	Entrances  map[uint8]bool
+	Supertiles map[Supertile]bool
+
+	// Reachable also keeps the rooms reachable from the selected supertiles:
+	Reachable bool
+}
+
+func (s *Selection) IncludesEntrance(eID uint8) bool {
+	return s.Entrances == nil || s.Entrances[eID]
+}
+
+func (s *Selection) IncludesSupertile(st Supertile) bool {
+	return s.Supertiles == nil || s.Supertiles[st]
+}
@@ -0,0 +1,169 @@
+package underworld
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Doorway identifies the half of a supertile edge that a door, doorway or walkway passes through;
+// supertiles are made of 2x2 rooms so each edge has two halves, 0 being north or west:
+type Doorway struct {
+	Supertile Supertile
+	Edge      Direction
+	Half      uint8
+}
+
+func (d Doorway) String() string {
+	return fmt.Sprintf("%s %s %d", d.Supertile, d.Edge, d.Half)
+}
+
+// edgeOffsets splits t into its offset along the given edge and its distance away from that edge:
+func edgeOffsets(t MapCoord, edge Direction) (lyr, along, depth uint16) {
+	lyr, row, col := t.RowCol()
+	switch edge {
+	case DirNorth:
+		along, depth = col, row
+	case DirSouth:
+		along, depth = col, 0x3F-row
+	case DirWest:
+		along, depth = row, col
+	case DirEast:
+		along, depth = row, 0x3F-col
+	}
+	return
+}
+
+func fromEdgeOffsets(lyr uint16, edge Direction, along, depth uint16) MapCoord {
+	switch edge {
+	case DirNorth:
+		return MapCoord(lyr | depth<<6 | along)
+	case DirSouth:
+		return MapCoord(lyr | (0x3F-depth)<<6 | along)
+	case DirWest:
+		return MapCoord(lyr | along<<6 | depth)
+	case DirEast:
+		return MapCoord(lyr | along<<6 | (0x3F - depth))
+	}
+	panic("bad direction")
+}
+
+// transition finds where leaving supertile this at t heading in dir leads to. arrive is where the vanilla
+// layout places Link in the neighbouring supertile; it is moved to the paired doorway if rewired:
+func (c *Config) transition(this Supertile, t, arrive MapCoord, dir Direction) (sn Supertile, tn MapCoord, sd Direction, ok bool) {
+	_, along, _ := edgeOffsets(t, dir)
+	from := Doorway{this, dir, uint8(along >> 5)}
+
+	to, rewired := c.DoorPairs[from]
+	if !rewired {
+		sn, sd, ok = this.MoveBy(dir)
+		tn = arrive
+		return
+	}
+
+	// keep the same position within the edge half and distance into the room:
+	lyr, along, depth := edgeOffsets(arrive, dir.Opposite())
+	along = uint16(to.Half)<<5 | along&0x1F
+	sn, tn, sd, ok = to.Supertile, fromEdgeOffsets(lyr, to.Edge, along, depth), to.Edge.Opposite(), true
+	return
+}
+
+func parseDirection(s string) (d Direction, err error) {
+	switch strings.ToLower(s) {
+	case "north", "n":
+		d = DirNorth
+	case "south", "s":
+		d = DirSouth
+	case "west", "w":
+		d = DirWest
+	case "east", "e":
+		d = DirEast
+	default:
+		err = fmt.Errorf("bad direction %q", s)
+	}
+	return
+}
+
+// parseHex parses a hexadecimal number with an optional "$" or "0x" prefix:
+func parseHex(s string, bitSize int) (uint64, error) {
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return strconv.ParseUint(s, 16, bitSize)
+}
+
+func parseDoorway(fields []string) (d Doorway, err error) {
+	var st, half uint64
+	if st, err = parseHex(fields[0], 16); err != nil {
+		return
+	}
+	if st >= 0x128 {
+		err = fmt.Errorf("supertile $%03x out of range", st)
+		return
+	}
+	if d.Edge, err = parseDirection(fields[1]); err != nil {
+		return
+	}
+	if half, err = parseHex(fields[2], 8); err != nil {
+		return
+	}
+	if half > 1 {
+		err = fmt.Errorf("edge half must be 0 or 1, got %d", half)
+		return
+	}
+	d.Supertile, d.Half = Supertile(st), uint8(half)
+	return
+}
+
+// LoadDoorPairs reads a door randomizer's connections for Config.DoorPairs, one
+// "<supertile> <edge> <half> <supertile> <edge> <half>" pair per line, e.g. "012 north 0 0a8 west 1". Each pair
+// links both ways unless the reverse is listed separately; n is the number of pairs listed:
+func LoadDoorPairs(path string) (doorPairs map[Doorway]Doorway, n int, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	doorPairs = make(map[Doorway]Doorway)
+	explicit := make(map[Doorway]bool)
+
+	s := bufio.NewScanner(f)
+	for ln := 1; s.Scan(); ln++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 6 {
+			return nil, 0, fmt.Errorf("doors: %s:%d: expected 6 fields, got %d", path, ln, len(fields))
+		}
+
+		var from, to Doorway
+		if from, err = parseDoorway(fields[0:3]); err != nil {
+			return nil, 0, fmt.Errorf("doors: %s:%d: %w", path, ln, err)
+		}
+		if to, err = parseDoorway(fields[3:6]); err != nil {
+			return nil, 0, fmt.Errorf("doors: %s:%d: %w", path, ln, err)
+		}
+		if explicit[from] {
+			return nil, 0, fmt.Errorf("doors: %s:%d: doorway %s listed twice", path, ln, from)
+		}
+
+		explicit[from] = true
+		doorPairs[from] = to
+		if !explicit[to] {
+			doorPairs[to] = from
+		}
+	}
+	if err = s.Err(); err != nil {
+		return
+	}
+
+	n = len(explicit)
+	return
+}
@@ -0,0 +1,65 @@
+package underworld
+
+import (
+	"github.com/alttpo/mapgen/emulator"
+)
+
+// LinkStep is where Link stood after one frame of a walk:
+type LinkStep struct {
+	Frame int
+	Supertile
+	Point MapCoord
+}
+
+// linkPoint returns the tile under Link's feet; the inverse of MapCoord.ToAbsCoord:
+func linkPoint(wram []byte) MapCoord {
+	return AbsToMapCoord(read16(wram, 0x22), read16(wram, 0x20)+0xE, read16(wram, 0xEE))
+}
+
+// linkDirections maps Link's facing direction in $2F to ours:
+var linkDirections = [...]Direction{0: DirNorth, 2: DirSouth, 4: DirWest, 6: DirEast}
+
+// LinkEntryPoint is where and which way Link stands in the room's loaded state:
+func (room *RoomState) LinkEntryPoint() EntryPoint {
+	wram := (&room.WRAM)[:]
+	d := DirNorth
+	if f := read8(wram, 0x2F); int(f) < len(linkDirections) && f&1 == 0 {
+		d = linkDirections[f]
+	}
+	return EntryPoint{Supertile: room.Supertile, Point: linkPoint(wram), Direction: d}
+}
+
+// WalkLink plays the joypad script on a copy of the room's loaded state, running the underworld module a frame
+// at a time, and returns where Link stood after each frame. The walk ends early if Link leaves the supertile:
+func (room *RoomState) WalkLink(script []emulator.JoypadInput) (steps []LinkStep, err error) {
+	e := &emulator.System{}
+	if err = e.InitEmulatorFrom(&room.e); err != nil {
+		return
+	}
+	e.Joypad = emulator.Joypad{Script: script}
+
+	wram := e.WRAM[:]
+	err = room.cfg.Harness.StepUnderworld(e, func(frame int) bool {
+		st := Supertile(read16(wram, 0xA0))
+		steps = append(steps, LinkStep{Frame: frame, Supertile: st, Point: linkPoint(wram)})
+		return st == room.Supertile
+	})
+	return
+}
+
+// UnreachedSteps returns the steps of a walk inside the room that stood on tiles FindReachableTiles did not
+// mark as reachable; each tile is reported once:
+func (room *RoomState) UnreachedSteps(steps []LinkStep) (missed []LinkStep) {
+	seen := make(map[MapCoord]empty)
+	for _, s := range steps {
+		if s.Supertile != room.Supertile || room.Reachable[s.Point] != 0x01 {
+			continue
+		}
+		if _, ok := seen[s.Point]; ok {
+			continue
+		}
+		seen[s.Point] = empty{}
+		missed = append(missed, s)
+	}
+	return
+}
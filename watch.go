@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/alttpo/mapgen/emulator"
+	"sync"
+)
+
+// watchList is a flag.Value collecting -watch watchpoints in the order they are given:
+type watchList []emulator.Watchpoint
+
+func (l *watchList) String() string { return fmt.Sprintf("%d watchpoint(s)", len(*l)) }
+
+func (l *watchList) Set(s string) (err error) {
+	var w emulator.Watchpoint
+	if w, err = emulator.ParseWatchpoint(s); err != nil {
+		return
+	}
+	*l = append(*l, w)
+	return
+}
+
+var (
+	watchpoints  watchList
+	watchChanges bool
+
+	watchLock sync.Mutex // hits of rooms worked on in parallel are printed a line at a time
+)
+
+func addWatchFlags(fs *flag.FlagSet) {
+	fs.Var(&watchpoints, "watch", "print accesses to memory: [r|w|rw:][wram|vram|sram:]range, e.g. 7E:0414 or r:vram:4000-4FFF; may be repeated")
+	fs.BoolVar(&watchChanges, "watchchanges", false, "print only -watch writes that change the value")
+}
+
+// startWatch adds the -watch watchpoints to the initialized system, which all systems cloned from it share. Like
+// tracing, watching starts after the game's initialization:
+func startWatch(e *emulator.System) {
+	if len(watchpoints) == 0 {
+		return
+	}
+
+	for _, w := range watchpoints {
+		w.Changes = watchChanges
+		w.OnAccess = printWatchHit
+		e.AddWatchpoint(w)
+	}
+}
+
+func printWatchHit(s *emulator.System, hit emulator.WatchHit) {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	fmt.Printf("watch: %s at %s in supertile $%03x\n", hit, s.AddrName(hit.PC), s.ReadWRAM16(0xA0))
+}